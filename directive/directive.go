@@ -0,0 +1,141 @@
+//
+// directive.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+// Package directive implements lgrep directives. The directives are
+// embedded into datalog files as comment lines that start with the
+// "%@" prefix:
+//
+//	%@ handler myd myd_worker `^Starting worker (?P<id>\S+)` id:int
+//
+// The directive arguments are separated by whitespace. The arguments
+// can be quoted with double quotes, using the Go string literal
+// escapes, or with backquotes as raw strings.
+package directive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Prefix starts directive lines.
+const Prefix = "%@"
+
+// Directive implements an lgrep directive.
+type Directive struct {
+	Position string
+	Name     string
+	Args     []string
+}
+
+func (d *Directive) String() string {
+	return fmt.Sprintf("%s %s", d.Name, strings.Join(d.Args, " "))
+}
+
+// Errorf creates an error that is prefixed with the directive
+// position.
+func (d *Directive) Errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s: %s", d.Position, d.Name,
+		fmt.Sprintf(format, a...))
+}
+
+// ParseFile parses the directives from the file.
+func ParseFile(file string) ([]*Directive, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(file, f)
+}
+
+// Parse parses the directives from the input. All non-directive
+// lines are ignored.
+func Parse(name string, in io.Reader) ([]*Directive, error) {
+	var result []*Directive
+
+	scanner := bufio.NewScanner(in)
+	var line int
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, Prefix) {
+			continue
+		}
+		pos := fmt.Sprintf("%s:%d", name, line)
+		args, err := split(text[len(Prefix):])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", pos, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s: empty directive", pos)
+		}
+		result = append(result, &Directive{
+			Position: pos,
+			Name:     args[0],
+			Args:     args[1:],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func split(line string) ([]string, error) {
+	var result []string
+
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if len(line) == 0 {
+			return result, nil
+		}
+		var arg string
+		switch line[0] {
+		case '"', '`':
+			end := closingQuote(line)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string: %s", line)
+			}
+			var err error
+			arg, err = strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %s",
+					line[:end+1], err)
+			}
+			line = line[end+1:]
+
+		default:
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			arg = line[:end]
+			line = line[end:]
+		}
+		result = append(result, arg)
+	}
+}
+
+func closingQuote(line string) int {
+	quote := line[0]
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,54 @@
+//
+// diff.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package harness
+
+import (
+	"fmt"
+)
+
+// diff compares the expected and actual lines. The function returns
+// the missing lines, prefixed with '-', and the unexpected lines,
+// prefixed with '+', in the order of the longest common subsequence
+// of the lines.
+func diff(name string, want, got []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of
+	// want[i:] and got[j:].
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var result []string
+	var i, j int
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			i++
+			j++
+		case j >= len(got) || (i < len(want) && lcs[i+1][j] >= lcs[i][j+1]):
+			result = append(result, fmt.Sprintf("%s: -%s", name, want[i]))
+			i++
+		default:
+			result = append(result, fmt.Sprintf("%s: +%s", name, got[j]))
+			j++
+		}
+	}
+	return result
+}
@@ -0,0 +1,279 @@
+//
+// harness.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+// Package harness implements golden file tests for the syslog
+// handlers and the datalog rules. A test is a directory that holds
+// the test inputs and the expected results:
+//
+//	input*       log files, WEF XML event files, or traffic captures
+//	handlers.dl  optional syslog handler definitions
+//	rules.dl     optional rules and queries
+//	facts.dl     expected facts
+//	output.txt   expected query results
+//
+// The inputs are replayed in name order, as with the lgrep replay
+// command. The facts.dl file lists the expected facts of the
+// predicates that the test checks: for each predicate in the file, the
+// test compares all facts of the predicate in the order they were
+// added. The other predicates are not checked. The facts are compared
+// as text, one fact per line, and the lines starting with '%' are
+// comments. The output.txt file lists the expected query results of
+// the rules.dl queries.
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/output"
+	"github.com/markkurossi/lgrep/server"
+	"github.com/markkurossi/lgrep/store"
+)
+
+// Test files.
+const (
+	DefaultRoot  = "testdata"
+	InputPrefix  = "input"
+	HandlersFile = "handlers.dl"
+	RulesFile    = "rules.dl"
+	FactsFile    = "facts.dl"
+	OutputFile   = "output.txt"
+)
+
+// Reference is the reference time for inferring the years of the
+// input timestamps. With the default reference, the timestamps
+// without a year are in 2024.
+var Reference = time.Date(2024, time.December, 25, 0, 0, 0, 0, time.UTC)
+
+// Result defines a test result. The Diffs describe the differences
+// between the expected and the actual results. The Err is set if the
+// test could not be run.
+type Result struct {
+	Dir   string
+	Diffs []string
+	Err   error
+}
+
+// Failed tests if the test failed.
+func (r *Result) Failed() bool {
+	return r.Err != nil || len(r.Diffs) > 0
+}
+
+// Find finds the test directories under the root directory. The test
+// directories contain input files. If the root is empty, the function
+// searches the DefaultRoot directory.
+func Find(root string) ([]string, error) {
+	if len(root) == 0 {
+		root = DefaultRoot
+	}
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		inputs, err := inputs(path)
+		if err != nil {
+			return err
+		}
+		if len(inputs) > 0 {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// inputs returns the input files of the test directory in name
+// order.
+func inputs(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), InputPrefix) {
+			result = append(result, f.Name())
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// recorder records the facts that are added to the clause database.
+type recorder struct {
+	datalog.DB
+	facts []*datalog.Clause
+	on    bool
+}
+
+func (r *recorder) Add(clause *datalog.Clause) {
+	if r.on && clause.IsFact() {
+		r.facts = append(r.facts, clause)
+	}
+	r.DB.Add(clause)
+}
+
+// Run runs the test of the directory. If update is true, the function
+// writes the actual results to the expected results files instead of
+// comparing them.
+func Run(dir string, update bool) *Result {
+	result := &Result{
+		Dir: dir,
+	}
+	facts, out, err := run(dir)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if update {
+		result.Err = write(dir, facts, out)
+		return result
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, FactsFile))
+	if err != nil && !os.IsNotExist(err) {
+		result.Err = err
+		return result
+	}
+	want := lines(data)
+	checked := make(map[string]bool)
+	for _, line := range want {
+		name, err := predicate(line)
+		if err != nil {
+			result.Err = fmt.Errorf("%s: %s", FactsFile, err)
+			return result
+		}
+		checked[name] = true
+	}
+	var got []string
+	for _, c := range facts {
+		if checked[output.PredicateName(c.Head.Predicate)] {
+			got = append(got, c.String()+".")
+		}
+	}
+	result.Diffs = append(result.Diffs, diff(FactsFile, want, got)...)
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, OutputFile))
+	if err != nil && !os.IsNotExist(err) {
+		result.Err = err
+		return result
+	}
+	result.Diffs = append(result.Diffs,
+		diff(OutputFile, lines(data), lines(out))...)
+
+	return result
+}
+
+// run replays the test inputs. The function returns the facts that
+// the inputs produced and the query results.
+func run(dir string) ([]*datalog.Clause, []byte, error) {
+	db := &recorder{
+		DB: store.NewMemDB(),
+	}
+	out := new(bytes.Buffer)
+
+	s := server.New(db)
+	defer s.Close()
+	s.Results = out
+	s.Syslog.SetNextID(1)
+
+	file := filepath.Join(dir, RulesFile)
+	if exists(file) {
+		err := s.Eval(file)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	s.Outputs.SetConfig(new(output.Config))
+
+	file = filepath.Join(dir, HandlersFile)
+	if exists(file) {
+		err := s.Syslog.LoadHandlers(file)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	names, err := inputs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	db.on = true
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return nil, nil, err
+		}
+		err = s.Replay(f, name, Reference)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return db.facts, out.Bytes(), nil
+}
+
+// write writes the facts and the query results to the expected
+// results files. The query results are written only if the test has
+// rules.
+func write(dir string, facts []*datalog.Clause, out []byte) error {
+	buf := new(bytes.Buffer)
+	for _, c := range facts {
+		fmt.Fprintf(buf, "%s.\n", c)
+	}
+	err := ioutil.WriteFile(filepath.Join(dir, FactsFile), buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	if !exists(filepath.Join(dir, RulesFile)) {
+		return nil
+	}
+	return ioutil.WriteFile(filepath.Join(dir, OutputFile), out, 0644)
+}
+
+// predicate returns the predicate name of the fact line.
+func predicate(line string) (string, error) {
+	t, err := datalog.NewLexer("fact", strings.NewReader(line)).GetToken()
+	if err != nil {
+		return "", err
+	}
+	if t.Type != datalog.TokenIdentifier && t.Type != datalog.TokenString {
+		return "", fmt.Errorf("invalid fact '%s'", line)
+	}
+	return t.Value, nil
+}
+
+// lines returns the non-empty lines of the data. The lines starting
+// with '%' are comments.
+func lines(data []byte) []string {
+	var result []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && line[0] != '%' {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
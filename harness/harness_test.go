@@ -0,0 +1,36 @@
+//
+// harness_test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package harness
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGolden(t *testing.T) {
+	dirs, err := Find(filepath.Join("..", DefaultRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no tests found")
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			result := Run(dir, false)
+			if result.Err != nil {
+				t.Fatal(result.Err)
+			}
+			for _, d := range result.Diffs {
+				t.Error(d)
+			}
+		})
+	}
+}
@@ -16,18 +16,76 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/markkurossi/datalog"
 	"github.com/markkurossi/lgrep/server"
+	"github.com/markkurossi/lgrep/store"
+	"github.com/markkurossi/lgrep/syslog"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "query", "shell":
+			runClient(os.Args[1], os.Args[2:])
+			return
+
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+
+		case "test":
+			runTest(os.Args[2:])
+			return
+		}
+	}
+
 	verbose := flag.Bool("v", false, "Verbose output.")
 	init := flag.String("init", "", "Init file.")
+	dbDir := flag.String("db", "", "Persistent clause database directory.")
+	api := flag.String("api", "",
+		"Start query API server at TCP address or Unix socket path.")
+	compact := flag.Duration("compact", time.Minute,
+		"Interval for enforcing fact retention policies.")
+	syncInterval := flag.Duration("sync", 0,
+		"Minimum interval between database commits to stable storage\n"+
+			"(0 commits every received event)")
 	wef := flag.String("wef", "", "Start Windows Event Forwarding server.")
+	udp := flag.String("udp", ":1514", "Syslog UDP address.")
+	handlers := flag.String("handlers", "", "Syslog handler definitions file.")
+	timezones := flag.String("timezones", "",
+		"Syslog source time zones file.")
+	tcp := flag.String("tcp", "", "Start syslog TCP server.")
+	relp := flag.String("relp", "", "Start syslog RELP server.")
+	unix := flag.String("unix", "", "Start local syslog Unix stream server.")
+	unixgram := flag.String("unixgram", "",
+		"Start local syslog Unix datagram server.")
+	tlsAddr := flag.String("tls", "", "Start syslog TLS server.")
+	tlsCert := flag.String("tls-cert", "syslog.crt",
+		"Syslog TLS server certificate PEM file.")
+	tlsKey := flag.String("tls-key", "syslog.key",
+		"Syslog TLS server private key PEM file.")
+	tlsCA := flag.String("tls-ca", "",
+		"Require syslog TLS client certificates signed by the CA PEM file.")
 	flag.Parse()
 
-	server := server.New(datalog.NewMemDB())
+	var db datalog.DB
+	if len(*dbDir) > 0 {
+		d, err := store.Open(*dbDir)
+		if err != nil {
+			log.Fatalf("Failed to open database: %s\n", err)
+		}
+		d.SyncInterval = *syncInterval
+		db = d
+	} else {
+		db = store.NewMemDB()
+	}
+
+	server := server.New(db)
 	server.Verbose(*verbose)
 
 	if len(*init) > 0 {
@@ -37,6 +95,47 @@ func main() {
 		}
 	}
 
+	if len(*handlers) > 0 {
+		err := server.Syslog.LoadHandlers(*handlers)
+		if err != nil {
+			log.Fatalf("Failed to read handlers file: %s\n", err)
+		}
+	}
+
+	if len(*timezones) > 0 {
+		err := server.Syslog.LoadTimezones(*timezones)
+		if err != nil {
+			log.Fatalf("Failed to read time zones file: %s\n", err)
+		}
+	}
+
+	go server.RunCompactor(*compact)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			err := server.Reload()
+			if err != nil {
+				log.Printf("Reload failed: %s\n", err)
+			} else {
+				log.Printf("Reloaded configuration\n")
+			}
+		}
+	}()
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-term
+		log.Printf("Received %s, shutting down\n", sig)
+		err := server.Close()
+		if err != nil {
+			log.Fatalf("Failed to close database: %s\n", err)
+		}
+		os.Exit(0)
+	}()
+
 	if len(*wef) > 0 {
 		key, err := loadKey("wef")
 		if err != nil {
@@ -66,7 +165,72 @@ func main() {
 		go server.WEF.ServeHTTPS(*wef, config)
 	}
 
-	server.Syslog.ServeUDP(":1514")
+	if len(*api) > 0 {
+		go func() {
+			err := server.ServeAPI(*api)
+			if err != nil {
+				log.Fatalf("API server failed: %s\n", err)
+			}
+		}()
+	}
+
+	if len(*tcp) > 0 {
+		go func() {
+			err := server.Syslog.ServeTCP(*tcp)
+			if err != nil {
+				log.Fatalf("Syslog TCP server failed: %s\n", err)
+			}
+		}()
+	}
+
+	if len(*relp) > 0 {
+		if len(*dbDir) == 0 || *syncInterval > 0 {
+			log.Printf("Syslog RELP: acknowledged events are not durable " +
+				"without -db and -sync 0\n")
+		}
+		go func() {
+			err := server.Syslog.ServeRELP(*relp)
+			if err != nil {
+				log.Fatalf("Syslog RELP server failed: %s\n", err)
+			}
+		}()
+	}
+
+	if len(*unix) > 0 {
+		go func() {
+			err := server.Syslog.ServeUnix(*unix)
+			if err != nil {
+				log.Fatalf("Syslog Unix server failed: %s\n", err)
+			}
+		}()
+	}
+
+	if len(*unixgram) > 0 {
+		go func() {
+			err := server.Syslog.ServeUnixgram(*unixgram)
+			if err != nil {
+				log.Fatalf("Syslog Unix datagram server failed: %s\n", err)
+			}
+		}()
+	}
+
+	if len(*tlsAddr) > 0 {
+		config, err := syslog.NewTLSConfig(*tlsCert, *tlsKey, *tlsCA)
+		if err != nil {
+			log.Fatalf("Failed to create syslog TLS config: %s\n", err)
+		}
+		go func() {
+			err := server.Syslog.ServeTLS(*tlsAddr, config)
+			if err != nil {
+				log.Fatalf("Syslog TLS server failed: %s\n", err)
+			}
+		}()
+	}
+
+	err := server.Syslog.ServeUDP(*udp)
+	if err != nil {
+		log.Fatalf("Syslog UDP server failed: %s\n", err)
+	}
 }
 
 func loadKey(path string) (*rsa.PrivateKey, error) {
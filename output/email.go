@@ -0,0 +1,78 @@
+//
+// email.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Email sends alerts with email through an SMTP server.
+type Email struct {
+	Server  string
+	From    string
+	To      []string
+	Subject string
+}
+
+func newEmail(opts options) (Sink, error) {
+	to, err := opts.required("to")
+	if err != nil {
+		return nil, err
+	}
+	from := opts.get("from", "")
+	if len(from) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		from = fmt.Sprintf("lgrep@%s", hostname)
+	}
+	return &Email{
+		Server:  opts.get("server", "localhost:25"),
+		From:    from,
+		To:      strings.Split(to, ","),
+		Subject: opts.get("subject", "lgrep alert"),
+	}, nil
+}
+
+func (e *Email) String() string {
+	return fmt.Sprintf("email:%s", strings.Join(e.To, ","))
+}
+
+// Send implements Sink.Send.
+func (e *Email) Send(alert *Alert) error {
+	var names []string
+	for name := range alert.Bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s: %s\r\n", e.Subject, alert.Predicate)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n")
+	fmt.Fprintf(&msg, "Query: %s\r\n", alert.Query)
+	fmt.Fprintf(&msg, "Result: %s\r\n", alert.Fact)
+	fmt.Fprintf(&msg, "Time: %s\r\n", alert.Time.Format(time.RFC3339))
+	fmt.Fprintf(&msg, "\r\n")
+	for _, name := range names {
+		fmt.Fprintf(&msg, "%s = %s\r\n", name, alert.Bindings[name])
+	}
+
+	return smtp.SendMail(e.Server, nil, e.From, e.To, msg.Bytes())
+}
@@ -0,0 +1,75 @@
+//
+// exec.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package output
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Exec runs a command for alerts. The alert is passed to the command
+// in environment variables:
+//
+//	LGREP_QUERY      the query
+//	LGREP_PREDICATE  the result predicate
+//	LGREP_FACT       the result fact
+//	LGREP_TIME       the result time in RFC 3339 format
+//	LGREP_VAR_NAME   the value of the query variable NAME
+type Exec struct {
+	Command string
+	Timeout time.Duration
+}
+
+func newExec(opts options) (Sink, error) {
+	command, err := opts.required("command")
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := opts.duration("timeout", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &Exec{
+		Command: command,
+		Timeout: timeout,
+	}, nil
+}
+
+func (e *Exec) String() string {
+	return fmt.Sprintf("exec:%s", e.Command)
+}
+
+// Send implements Sink.Send.
+func (e *Exec) Send(alert *Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.Command)
+	cmd.Env = append(os.Environ(),
+		"LGREP_QUERY="+alert.Query,
+		"LGREP_PREDICATE="+alert.Predicate,
+		"LGREP_FACT="+alert.Fact,
+		"LGREP_TIME="+alert.Time.Format(time.RFC3339Nano))
+	for name, value := range alert.Bindings {
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("LGREP_VAR_%s=%s", strings.ToUpper(name), value))
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(output) > 0 {
+			return fmt.Errorf("%s: %s", err, strings.TrimSpace(string(output)))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+//
+// file.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// File writes alerts as JSON lines to a file.
+type File struct {
+	Name string
+}
+
+func newFile(opts options) (Sink, error) {
+	name, err := opts.required("file")
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		Name: name,
+	}, nil
+}
+
+func (f *File) String() string {
+	return fmt.Sprintf("jsonl:%s", f.Name)
+}
+
+// Send implements Sink.Send.
+func (f *File) Send(alert *Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return appendLine(f.Name, data)
+}
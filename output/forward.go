@@ -0,0 +1,107 @@
+//
+// forward.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Forward sends alerts as RFC 5424 syslog messages. The message
+// contains the alert as a JSON object and the alert predicate is the
+// message MSGID. The hostname and tag are the message HOSTNAME and
+// APP-NAME. The TCP messages are framed with octet counting.
+type Forward struct {
+	Network  string
+	Address  string
+	Tag      string
+	Hostname string
+}
+
+// forwardPri defines the priority of the forwarded alerts:
+// user-level, warning.
+const forwardPri = 1*8 + 4
+
+func newForward(opts options) (Sink, error) {
+	addr, err := opts.required("addr")
+	if err != nil {
+		return nil, err
+	}
+	network := opts.get("network", "udp")
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("invalid network '%s'", network)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &Forward{
+		Network:  network,
+		Address:  addr,
+		Tag:      opts.get("tag", "lgrep"),
+		Hostname: hostname,
+	}, nil
+}
+
+func (f *Forward) String() string {
+	return fmt.Sprintf("syslog:%s/%s", f.Network, f.Address)
+}
+
+// Send implements Sink.Send.
+func (f *Forward) Send(alert *Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", forwardPri,
+		time.Now().UTC().Format(time.RFC3339Nano),
+		headerField(f.Hostname, maxHostname), headerField(f.Tag, maxAppName),
+		os.Getpid(), headerField(alert.Predicate, maxMsgID), data)
+	if f.Network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	conn, err := net.DialTimeout(f.Network, f.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+// The maximum lengths of the RFC 5424 header fields.
+const (
+	maxHostname = 255
+	maxAppName  = 48
+	maxMsgID    = 32
+)
+
+// headerField returns the value as an RFC 5424 header field of at most
+// max characters. The header fields may contain only printable
+// US-ASCII characters. If the value is empty or it contains other
+// characters, the function returns the nil value "-".
+func headerField(value string, max int) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 33 || value[i] > 126 {
+			return "-"
+		}
+	}
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
+}
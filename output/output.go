@@ -0,0 +1,372 @@
+//
+// output.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+// Package output implements the alert outputs of query results. The
+// outputs are bound to queries with the output directives of the init
+// files:
+//
+//	%@ output sshd_attack webhook url=https://alerts.example.com/lgrep
+//	%@ output sshd_attack jsonl file=/var/log/lgrep/alerts.jsonl
+//	%@ deadletter /var/log/lgrep/deadletter.jsonl
+//
+// The alerts are delivered asynchronously. Failed deliveries are
+// retried and the alerts that could not be delivered are written to
+// the dead-letter file.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/markkurossi/datalog"
+)
+
+// Alert implements a query result that is sent to outputs.
+type Alert struct {
+	Query     string            `json:"query"`
+	Predicate string            `json:"predicate"`
+	Fact      string            `json:"fact"`
+	Time      time.Time         `json:"time"`
+	Bindings  map[string]string `json:"bindings"`
+	// Suppressed is set for summary alerts. It tells how many alerts
+	// were suppressed after the previous alert.
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// NewAlert creates an alert from the query and its result fact.
+func NewAlert(query, result *datalog.Clause) *Alert {
+	alert := &Alert{
+		Query:     query.String(),
+		Predicate: PredicateName(result.Head.Predicate),
+		Fact:      result.String(),
+		Time:      time.Unix(0, result.Timestamp),
+		Bindings:  Bindings(query, result),
+	}
+	return alert
+}
+
+// Bindings returns the values of the query variables in the query
+// result.
+func Bindings(query, result *datalog.Clause) map[string]string {
+	bindings := make(map[string]string)
+	for i, t := range query.Head.Terms {
+		v, ok := t.(*datalog.TermVariable)
+		if !ok || i >= len(result.Head.Terms) {
+			continue
+		}
+		name := v.Symbol.String()
+		if name == "_" {
+			continue
+		}
+		switch c := result.Head.Terms[i].(type) {
+		case *datalog.TermConstant:
+			bindings[name] = c.Value
+		default:
+			bindings[name] = c.String()
+		}
+	}
+	return bindings
+}
+
+// PredicateName returns the predicate name without the quotes of the
+// stringlike predicates.
+func PredicateName(sym datalog.Symbol) string {
+	name := sym.String()
+	unquoted, err := strconv.Unquote(name)
+	if err == nil {
+		return unquoted
+	}
+	return name
+}
+
+// Sink delivers alerts to an external system.
+type Sink interface {
+	Send(alert *Alert) error
+	String() string
+}
+
+// Output binds a sink to the queries of a predicate.
+type Output struct {
+	Predicate string
+	Sink      Sink
+	Retries   int
+	Backoff   time.Duration
+}
+
+func (o *Output) String() string {
+	return fmt.Sprintf("%s => %s", o.Predicate, o.Sink)
+}
+
+// QueueSize defines the number of pending alerts of an output. If the
+// queue is full, the new alerts are written to the dead-letter file.
+var QueueSize = 1024
+
+// Config defines the outputs and throttles of the query predicates.
+type Config struct {
+	Outputs    []*Output
+	Throttles  []*Throttle
+	DeadLetter string
+}
+
+// Dispatcher delivers alerts to their outputs. Each output has its
+// own alert queue and delivery goroutine so that a slow or failing
+// sink does not delay the other outputs.
+type Dispatcher struct {
+	m          sync.Mutex
+	outputs    []*Output
+	workers    map[*Output]*worker
+	throttles  map[string]*Throttle
+	deadletter string
+	done       chan struct{}
+	closed     bool
+}
+
+// worker delivers the queued alerts of an output.
+type worker struct {
+	output *Output
+	queue  chan *Alert
+}
+
+// deadLetter implements the dead-letter file records.
+type deadLetter struct {
+	Alert *Alert `json:"alert"`
+	Sink  string `json:"sink"`
+	Error string `json:"error"`
+}
+
+// NewDispatcher creates a new alert dispatcher.
+func NewDispatcher() *Dispatcher {
+	d := &Dispatcher{
+		workers:   make(map[*Output]*worker),
+		throttles: make(map[string]*Throttle),
+		done:      make(chan struct{}),
+	}
+	go d.flush()
+	return d
+}
+
+// Close stops the dispatcher. The pending alerts are not delivered
+// and the alerts that are emitted after Close are dropped.
+func (d *Dispatcher) Close() {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.closed {
+		return
+	}
+	d.closed = true
+	close(d.done)
+}
+
+// SetConfig sets the dispatcher outputs, throttles, and the
+// dead-letter file.
+func (d *Dispatcher) SetConfig(c *Config) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.outputs = c.Outputs
+	d.startWorkers()
+	old := d.throttles
+	d.throttles = make(map[string]*Throttle)
+	for _, t := range c.Throttles {
+		if o, ok := old[t.Predicate]; ok && o.Equals(t) {
+			t = o
+		}
+		d.throttles[t.Predicate] = t
+	}
+	d.deadletter = c.DeadLetter
+}
+
+// AddConfig adds outputs and throttles to the dispatcher. If the
+// config's dead-letter file is not empty, it sets the dead-letter
+// file.
+func (d *Dispatcher) AddConfig(c *Config) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.outputs = append(d.outputs, c.Outputs...)
+	d.startWorkers()
+	for _, t := range c.Throttles {
+		d.throttles[t.Predicate] = t
+	}
+	if len(c.DeadLetter) > 0 {
+		d.deadletter = c.DeadLetter
+	}
+}
+
+// startWorkers starts the delivery goroutines of the new outputs and
+// stops the goroutines of the removed outputs. The stopped goroutines
+// deliver their pending alerts before they exit. The function must be
+// called with the dispatcher locked.
+func (d *Dispatcher) startWorkers() {
+	current := make(map[*Output]bool)
+	for _, o := range d.outputs {
+		current[o] = true
+		if _, ok := d.workers[o]; ok || d.closed {
+			continue
+		}
+		w := &worker{
+			output: o,
+			queue:  make(chan *Alert, QueueSize),
+		}
+		d.workers[o] = w
+		go d.run(w)
+	}
+	for o, w := range d.workers {
+		if !current[o] {
+			close(w.queue)
+			delete(d.workers, o)
+		}
+	}
+}
+
+// Emit sends the query result to the outputs of its predicate. The
+// function does not block on the delivery. The function returns
+// false if the result was suppressed by the predicate's throttle.
+func (d *Dispatcher) Emit(query, result *datalog.Clause) bool {
+	alert := NewAlert(query, result)
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	t, ok := d.throttles[alert.Predicate]
+	if ok {
+		now := time.Now()
+		d.summarize(t, now)
+		if !t.allow(alert, now) {
+			return false
+		}
+	}
+	d.deliver(alert)
+	return true
+}
+
+// deliver queues the alert to the outputs of its predicate.
+func (d *Dispatcher) deliver(alert *Alert) {
+	if d.closed {
+		return
+	}
+	for _, o := range d.outputs {
+		if o.Predicate != alert.Predicate {
+			continue
+		}
+		w, ok := d.workers[o]
+		if !ok {
+			continue
+		}
+		select {
+		case w.queue <- alert:
+		default:
+			go d.fail(alert, o, fmt.Errorf("alert queue full"))
+		}
+	}
+}
+
+// flush emits the summary alerts of the expired throttle windows.
+func (d *Dispatcher) flush() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case <-d.done:
+			return
+		case now = <-ticker.C:
+		}
+
+		d.m.Lock()
+		for _, t := range d.throttles {
+			d.summarize(t, now)
+		}
+		d.m.Unlock()
+	}
+}
+
+// summarize delivers the summary alerts of the throttle's expired
+// windows.
+func (d *Dispatcher) summarize(t *Throttle, now time.Time) {
+	for _, alert := range t.expire(now) {
+		log.Printf("%s: %d more suppressed\n", alert.Fact, alert.Suppressed)
+		d.deliver(alert)
+	}
+}
+
+// run delivers the alerts of the worker's output until the worker
+// queue is closed or the dispatcher is stopped.
+func (d *Dispatcher) run(w *worker) {
+	o := w.output
+	for {
+		var alert *Alert
+		var ok bool
+		select {
+		case <-d.done:
+			return
+		case alert, ok = <-w.queue:
+			if !ok {
+				return
+			}
+		}
+		backoff := o.Backoff
+		var err error
+		for attempt := 0; ; attempt++ {
+			err = o.Sink.Send(alert)
+			if err == nil || attempt >= o.Retries {
+				break
+			}
+			log.Printf("Output %s: %s, retrying in %s\n", o.Sink, err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err != nil {
+			d.fail(alert, o, err)
+		}
+	}
+}
+
+// fail writes the undelivered alert to the dead-letter file.
+func (d *Dispatcher) fail(alert *Alert, o *Output, err error) {
+	log.Printf("Output %s: delivery failed: %s\n", o.Sink, err)
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if len(d.deadletter) == 0 {
+		return
+	}
+	data, jerr := json.Marshal(&deadLetter{
+		Alert: alert,
+		Sink:  o.Sink.String(),
+		Error: err.Error(),
+	})
+	if jerr != nil {
+		log.Printf("Dead-letter: %s\n", jerr)
+		return
+	}
+	jerr = appendLine(d.deadletter, data)
+	if jerr != nil {
+		log.Printf("Dead-letter: %s\n", jerr)
+	}
+}
+
+// appendLine appends the data as a line to the file.
+func appendLine(file string, data []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+//
+// parse.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package output
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/directive"
+)
+
+// Default delivery parameters.
+const (
+	DefaultRetries = 3
+	DefaultBackoff = time.Second
+)
+
+// options implement the sink options of the output directives.
+type options map[string]string
+
+// get returns the option value and removes it from the options.
+func (o options) get(key, def string) string {
+	val, ok := o[key]
+	if !ok {
+		return def
+	}
+	delete(o, key)
+	return val
+}
+
+// required returns the required option value.
+func (o options) required(key string) (string, error) {
+	val := o.get(key, "")
+	if len(val) == 0 {
+		return "", fmt.Errorf("missing option '%s'", key)
+	}
+	return val, nil
+}
+
+func (o options) duration(key string, def time.Duration) (time.Duration, error) {
+	val := o.get(key, "")
+	if len(val) == 0 {
+		return def, nil
+	}
+	return time.ParseDuration(val)
+}
+
+var sinks = map[string]func(opts options) (Sink, error){
+	"jsonl":   newFile,
+	"webhook": newWebhook,
+	"syslog":  newForward,
+	"email":   newEmail,
+	"exec":    newExec,
+}
+
+// Parse parses the output, throttle, and dead-letter directives from
+// the file:
+//
+//	%@ output PREDICATE SINK [KEY=VALUE...]
+//	%@ throttle PREDICATE [key=VAR[,VAR...]] [window=DURATION] [rate=N/DURATION]
+//	%@ deadletter FILE
+//
+// The output directive binds the queries of the PREDICATE to the
+// SINK. The sinks are:
+//
+//	jsonl    file=FILE
+//	webhook  url=URL [timeout=DURATION]
+//	syslog   addr=HOST:PORT [network=udp|tcp] [tag=TAG]
+//	email    to=ADDR[,ADDR...] [from=ADDR] [server=HOST:PORT] [subject=TEXT]
+//	exec     command=PATH [timeout=DURATION]
+//
+// All sinks accept the retries=N (default 3) and backoff=DURATION
+// (default 1s) options that control the delivery retries.
+//
+// The throttle directive suppresses the duplicate alerts of the
+// PREDICATE for the window after an alert, and limits the number of
+// the PREDICATE alerts to N per DURATION. The key sets the query
+// variables that identify duplicate alerts. The key variables must be
+// variables of the heads of all queries of the PREDICATE in the
+// argument queries.
+func Parse(file string, queries []*datalog.Clause) (*Config, error) {
+	directives, err := directive.ParseFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := new(Config)
+
+	for _, d := range directives {
+		switch d.Name {
+		case "deadletter":
+			if len(d.Args) != 1 {
+				return nil, d.Errorf("usage: FILE")
+			}
+			config.DeadLetter = d.Args[0]
+
+		case "throttle":
+			t, err := parseThrottle(d, queries)
+			if err != nil {
+				return nil, err
+			}
+			config.Throttles = append(config.Throttles, t)
+
+		case "output":
+			if len(d.Args) < 2 {
+				return nil, d.Errorf("usage: PREDICATE SINK [KEY=VALUE...]")
+			}
+			newSink, ok := sinks[d.Args[1]]
+			if !ok {
+				return nil, d.Errorf("unknown sink '%s'", d.Args[1])
+			}
+			opts := make(options)
+			for _, arg := range d.Args[2:] {
+				idx := strings.IndexByte(arg, '=')
+				if idx < 0 {
+					return nil, d.Errorf("invalid option '%s'", arg)
+				}
+				opts[arg[:idx]] = arg[idx+1:]
+			}
+			o := &Output{
+				Predicate: d.Args[0],
+			}
+			o.Retries, err = strconv.Atoi(opts.get("retries",
+				strconv.Itoa(DefaultRetries)))
+			if err != nil {
+				return nil, d.Errorf("invalid retries: %s", err)
+			}
+			o.Backoff, err = opts.duration("backoff", DefaultBackoff)
+			if err != nil {
+				return nil, d.Errorf("invalid backoff: %s", err)
+			}
+			o.Sink, err = newSink(opts)
+			if err != nil {
+				return nil, d.Errorf("%s: %s", d.Args[1], err)
+			}
+			for key := range opts {
+				return nil, d.Errorf("%s: unknown option '%s'",
+					d.Args[1], key)
+			}
+			config.Outputs = append(config.Outputs, o)
+		}
+	}
+	return config, nil
+}
+
+func parseThrottle(d *directive.Directive,
+	queries []*datalog.Clause) (*Throttle, error) {
+	if len(d.Args) < 2 {
+		return nil, d.Errorf(
+			"usage: PREDICATE [key=VAR[,VAR...]] [window=DURATION] [rate=N/DURATION]")
+	}
+	t := &Throttle{
+		Predicate: d.Args[0],
+	}
+	opts := make(options)
+	for _, arg := range d.Args[1:] {
+		idx := strings.IndexByte(arg, '=')
+		if idx < 0 {
+			return nil, d.Errorf("invalid option '%s'", arg)
+		}
+		opts[arg[:idx]] = arg[idx+1:]
+	}
+	if keys := opts.get("key", ""); len(keys) > 0 {
+		t.Keys = strings.Split(keys, ",")
+		for _, key := range t.Keys {
+			if !queryVariable(t.Predicate, key, queries) {
+				return nil, d.Errorf("unknown key variable '%s'", key)
+			}
+		}
+	}
+	var err error
+	t.Window, err = opts.duration("window", 0)
+	if err != nil {
+		return nil, d.Errorf("invalid window: %s", err)
+	}
+	if rate := opts.get("rate", ""); len(rate) > 0 {
+		parts := strings.Split(rate, "/")
+		if len(parts) == 2 {
+			t.Rate, err = strconv.Atoi(parts[0])
+			if err == nil {
+				t.Per, err = time.ParseDuration(parts[1])
+			}
+		}
+		if len(parts) != 2 || err != nil || t.Rate <= 0 || t.Per <= 0 {
+			return nil, d.Errorf("invalid rate '%s'", rate)
+		}
+	}
+	for key := range opts {
+		return nil, d.Errorf("unknown option '%s'", key)
+	}
+	if t.Window <= 0 && t.Rate == 0 {
+		return nil, d.Errorf("window or rate required")
+	}
+	return t, nil
+}
+
+// queryVariable tests if the variable is a variable of the heads of
+// all queries of the predicate. The function returns false if there
+// are no queries of the predicate.
+func queryVariable(predicate, variable string,
+	queries []*datalog.Clause) bool {
+	var found bool
+	for _, q := range queries {
+		if PredicateName(q.Head.Predicate) != predicate {
+			continue
+		}
+		if !headVariable(q.Head, variable) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// headVariable tests if the variable is a term of the atom.
+func headVariable(atom *datalog.Atom, variable string) bool {
+	for _, t := range atom.Terms {
+		v, ok := t.(*datalog.TermVariable)
+		if ok && v.Symbol.String() == variable {
+			return true
+		}
+	}
+	return false
+}
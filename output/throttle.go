@@ -0,0 +1,148 @@
+//
+// throttle.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package output
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Throttle limits the alerts of the queries of a predicate. The
+// alerts with the same dedup key are suppressed for the Window after
+// an alert is emitted. The dedup key is formed from the values of the
+// Keys variables, or from all query variables if no Keys are set. The
+// Rate limits the number of alerts that are emitted during the Per
+// period. When a window or a rate period ends, a summary alert tells
+// how many alerts were suppressed. Each query of the predicate has
+// its own windows and rate periods.
+type Throttle struct {
+	Predicate string
+	Keys      []string
+	Window    time.Duration
+	Rate      int
+	Per       time.Duration
+
+	queries map[string]*throttleState
+}
+
+// throttleState holds the windows and the rate period of a query.
+type throttleState struct {
+	windows    map[string]*suppression
+	rateStart  time.Time
+	rateCount  int
+	rateLimits suppression
+}
+
+// suppression tracks the suppressed alerts.
+type suppression struct {
+	until time.Time
+	last  *Alert
+	count int
+}
+
+// summary returns the summary alert of the suppressed alerts.
+func (s *suppression) summary() *Alert {
+	alert := *s.last
+	alert.Suppressed = s.count
+	return &alert
+}
+
+// Equals tests if the throttles have the same configuration.
+func (t *Throttle) Equals(o *Throttle) bool {
+	return t.Predicate == o.Predicate &&
+		strings.Join(t.Keys, ",") == strings.Join(o.Keys, ",") &&
+		t.Window == o.Window && t.Rate == o.Rate && t.Per == o.Per
+}
+
+func (t *Throttle) key(alert *Alert) string {
+	keys := t.Keys
+	if len(keys) == 0 {
+		for name := range alert.Bindings {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+	}
+	var values []string
+	for _, name := range keys {
+		values = append(values, alert.Bindings[name])
+	}
+	return strings.Join(values, "\x00")
+}
+
+// allow tests if the alert is emitted at the argument time. The
+// expired windows and periods must be expired before calling allow.
+func (t *Throttle) allow(alert *Alert, now time.Time) bool {
+	if t.queries == nil {
+		t.queries = make(map[string]*throttleState)
+	}
+	st, ok := t.queries[alert.Query]
+	if !ok {
+		st = new(throttleState)
+		t.queries[alert.Query] = st
+	}
+
+	var key string
+	if t.Window > 0 {
+		key = t.key(alert)
+		s, ok := st.windows[key]
+		if ok && now.Before(s.until) {
+			s.last = alert
+			s.count++
+			return false
+		}
+	}
+	if t.Rate > 0 {
+		if now.Sub(st.rateStart) >= t.Per {
+			st.rateStart = now
+			st.rateCount = 0
+		}
+		if st.rateCount >= t.Rate {
+			st.rateLimits.last = alert
+			st.rateLimits.count++
+			return false
+		}
+		st.rateCount++
+	}
+	if t.Window > 0 {
+		if st.windows == nil {
+			st.windows = make(map[string]*suppression)
+		}
+		st.windows[key] = &suppression{
+			until: now.Add(t.Window),
+		}
+	}
+	return true
+}
+
+// expire expires the windows and rate periods that have ended at the
+// argument time. The function returns the summary alerts of the
+// expired windows and periods that suppressed alerts.
+func (t *Throttle) expire(now time.Time) []*Alert {
+	var result []*Alert
+	for _, st := range t.queries {
+		for key, s := range st.windows {
+			if now.Before(s.until) {
+				continue
+			}
+			if s.count > 0 {
+				result = append(result, s.summary())
+			}
+			delete(st.windows, key)
+		}
+		if t.Rate > 0 && now.Sub(st.rateStart) >= t.Per &&
+			st.rateLimits.count > 0 {
+			result = append(result, st.rateLimits.summary())
+			st.rateLimits = suppression{}
+			st.rateStart = now
+			st.rateCount = 0
+		}
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+//
+// webhook.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Webhook posts alerts as JSON objects to an HTTP URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func newWebhook(opts options) (Sink, error) {
+	url, err := opts.required("url")
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := opts.duration("timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &Webhook{
+		URL: url,
+		Client: &http.Client{
+			Timeout: timeout,
+		},
+	}, nil
+}
+
+func (w *Webhook) String() string {
+	return fmt.Sprintf("webhook:%s", w.URL)
+}
+
+// Send implements Sink.Send.
+func (w *Webhook) Send(alert *Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json",
+		bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return nil
+}
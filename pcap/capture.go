@@ -0,0 +1,247 @@
+//
+// capture.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+// MaxPending limits the amount of out-of-order data that is buffered
+// for a TCP stream. If the limit is exceeded, the stream skips the
+// missing data.
+const MaxPending = 1024 * 1024
+
+// Payload defines a UDP datagram payload or in-order TCP stream data
+// from a capture.
+type Payload struct {
+	// Time is the capture time of the packet.
+	Time time.Time
+	// Protocol is udp or tcp.
+	Protocol string
+	Src      net.Addr
+	Dst      net.Addr
+	// Stream identifies the TCP stream.
+	Stream string
+	Data   []byte
+	// Closed tells that the TCP stream ended. The closing payload
+	// does not have data.
+	Closed bool
+}
+
+// Capture reads the UDP and TCP payloads from a capture file. The
+// TCP segments are reassembled and the payloads contain the stream
+// data in order.
+type Capture struct {
+	// Ports selects the payloads by their source or destination
+	// ports. If the Ports is empty, all payloads are returned.
+	Ports   map[int]bool
+	reader  *Reader
+	decoder *decoder
+	streams map[string]*stream
+	pending []*Payload
+	eof     bool
+}
+
+// stream implements the reassembly of a TCP stream.
+type stream struct {
+	next    uint32
+	pending map[uint32][]byte
+	size    int
+}
+
+// NewCapture creates a new capture reader.
+func NewCapture(r io.Reader) (*Capture, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{
+		reader:  reader,
+		decoder: newDecoder(),
+		streams: make(map[string]*stream),
+	}, nil
+}
+
+// Next returns the next payload from the capture. At the end of the
+// capture, the function returns closing payloads for the open TCP
+// streams and then io.EOF. Undecodable packets are logged and
+// skipped.
+func (c *Capture) Next() (*Payload, error) {
+	for len(c.pending) == 0 {
+		if c.eof {
+			return nil, io.EOF
+		}
+		p, err := c.reader.Next()
+		if err == io.EOF {
+			c.eof = true
+			c.closeAll()
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		seg, err := c.decoder.decode(p)
+		if err != nil {
+			log.Printf("Packet %s: %s\n", p.Time.UTC().Format(time.RFC3339Nano),
+				err)
+			continue
+		}
+		if seg == nil {
+			continue
+		}
+		if len(c.Ports) > 0 && !c.Ports[seg.sport] && !c.Ports[seg.dport] {
+			continue
+		}
+		switch seg.proto {
+		case protoUDP:
+			c.pending = append(c.pending, &Payload{
+				Time:     seg.time,
+				Protocol: "udp",
+				Src:      &net.UDPAddr{IP: seg.src, Port: seg.sport},
+				Dst:      &net.UDPAddr{IP: seg.dst, Port: seg.dport},
+				Data:     seg.payload,
+			})
+		case protoTCP:
+			c.tcp(seg)
+		}
+	}
+	p := c.pending[0]
+	c.pending = c.pending[1:]
+	return p, nil
+}
+
+// tcp adds the TCP segment to its stream.
+func (c *Capture) tcp(seg *segment) {
+	src := &net.TCPAddr{IP: seg.src, Port: seg.sport}
+	dst := &net.TCPAddr{IP: seg.dst, Port: seg.dport}
+	key := fmt.Sprintf("%s-%s", src, dst)
+
+	s, ok := c.streams[key]
+	if ok && seg.flags&flagSYN != 0 && s.next != seg.seq+1 {
+		// New connection with the same addresses.
+		c.close(key, seg.time)
+		ok = false
+	}
+	if !ok {
+		if seg.flags&(flagFIN|flagRST) != 0 && len(seg.payload) == 0 {
+			return
+		}
+		s = &stream{
+			next:    seg.seq,
+			pending: make(map[uint32][]byte),
+		}
+		if seg.flags&flagSYN != 0 {
+			s.next++
+		}
+		c.streams[key] = s
+	}
+	emit := func(data []byte) {
+		c.pending = append(c.pending, &Payload{
+			Time:     seg.time,
+			Protocol: "tcp",
+			Src:      src,
+			Dst:      dst,
+			Stream:   key,
+			Data:     data,
+		})
+	}
+
+	data := seg.payload
+	seq := seg.seq
+	if seg.flags&flagSYN != 0 {
+		seq++
+	}
+	if len(data) > 0 {
+		if diff := int32(s.next - seq); diff > 0 {
+			// Retransmitted data.
+			if int(diff) >= len(data) {
+				data = nil
+			} else {
+				data = data[diff:]
+				seq = s.next
+			}
+		}
+		if len(data) > 0 {
+			if seq == s.next {
+				emit(data)
+				s.next += uint32(len(data))
+			} else if _, ok := s.pending[seq]; !ok {
+				s.pending[seq] = append([]byte(nil), data...)
+				s.size += len(data)
+			}
+		}
+		for len(s.pending) > 0 {
+			seq, data := s.first()
+			if diff := int32(s.next - seq); diff >= 0 {
+				delete(s.pending, seq)
+				s.size -= len(data)
+				if int(diff) < len(data) {
+					emit(data[diff:])
+					s.next += uint32(len(data) - int(diff))
+				}
+				continue
+			}
+			if s.size <= MaxPending {
+				break
+			}
+			log.Printf("TCP %s: skipping %d missing bytes\n", key, seq-s.next)
+			s.next = seq
+		}
+	}
+	if seg.flags&(flagFIN|flagRST) != 0 {
+		c.close(key, seg.time)
+	}
+}
+
+// first returns the first pending segment of the stream.
+func (s *stream) first() (uint32, []byte) {
+	var first uint32
+	var found bool
+	for seq := range s.pending {
+		if !found || int32(seq-first) < 0 {
+			first = seq
+			found = true
+		}
+	}
+	return first, s.pending[first]
+}
+
+// close closes the stream.
+func (c *Capture) close(key string, t time.Time) {
+	s, ok := c.streams[key]
+	if !ok {
+		return
+	}
+	if len(s.pending) > 0 {
+		log.Printf("TCP %s: %d bytes of data lost\n", key, s.size)
+	}
+	delete(c.streams, key)
+	c.pending = append(c.pending, &Payload{
+		Time:     t,
+		Protocol: "tcp",
+		Stream:   key,
+		Closed:   true,
+	})
+}
+
+// closeAll closes all open streams at the end of the capture.
+func (c *Capture) closeAll() {
+	var keys []string
+	for key := range c.streams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		c.close(key, c.reader.last)
+	}
+}
@@ -0,0 +1,113 @@
+//
+// capture_test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// testCapture reads all payloads of the raw IP packets.
+func testCapture(t *testing.T, ports map[int]bool,
+	packets ...[]byte) []*Payload {
+
+	var times []time.Time
+	for i := range packets {
+		times = append(times, testTime.Add(time.Duration(i)*time.Second))
+	}
+	c, err := NewCapture(bytes.NewReader(testPcap(binary.LittleEndian,
+		false, LinkRaw, times, packets...)))
+	if err != nil {
+		t.Fatalf("NewCapture failed: %s", err)
+	}
+	c.Ports = ports
+
+	var result []*Payload
+	for {
+		p, err := c.Next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %s", err)
+		}
+		result = append(result, p)
+	}
+}
+
+// streamData returns the data of the TCP payloads and tests that the
+// stream is closed after its data.
+func streamData(t *testing.T, payloads []*Payload) string {
+	var data []byte
+	for i, p := range payloads {
+		if p.Protocol != "tcp" {
+			t.Errorf("payload %d: protocol %s", i, p.Protocol)
+		}
+		if p.Closed != (i == len(payloads)-1) {
+			t.Errorf("payload %d: closed=%v", i, p.Closed)
+		}
+		data = append(data, p.Data...)
+	}
+	return string(data)
+}
+
+func testSegment(seq uint32, flags byte, data string) []byte {
+	return testIPv4("192.0.2.1", "192.0.2.2", protoTCP, 1, 0,
+		testTCP(40000, 601, seq, flags, []byte(data)))
+}
+
+func TestTCPReassembly(t *testing.T) {
+	const stream = "11 first event12 second event"
+
+	payloads := testCapture(t, nil,
+		testSegment(100, flagSYN, ""),
+		testSegment(101, 0x18, stream[:5]),
+		// Out-of-order segment.
+		testSegment(101+15, 0x18, stream[15:]),
+		// Retransmission overlapping the received data.
+		testSegment(101+3, 0x18, stream[3:15]),
+		testSegment(101+3, 0x18, stream[3:15]),
+		testSegment(101+uint32(len(stream)), flagFIN, ""),
+	)
+	if data := streamData(t, payloads); data != stream {
+		t.Errorf("got stream %q, expected %q", data, stream)
+	}
+	if p := payloads[0]; p.Src.String() != "192.0.2.1:40000" ||
+		p.Dst.String() != "192.0.2.2:601" ||
+		!p.Time.Equal(testTime.Add(time.Second)) {
+		t.Errorf("got payload %s->%s at %s", p.Src, p.Dst, p.Time)
+	}
+}
+
+func TestTCPUnclosed(t *testing.T) {
+	payloads := testCapture(t, nil,
+		testSegment(100, 0x18, "midstream"),
+		testSegment(109, 0x18, " data"),
+	)
+	if data := streamData(t, payloads); data != "midstream data" {
+		t.Errorf("got stream %q", data)
+	}
+}
+
+func TestPorts(t *testing.T) {
+	payloads := testCapture(t, map[int]bool{514: true},
+		testIPv4("192.0.2.1", "192.0.2.2", protoUDP, 1, 0,
+			testUDP(40000, 514, []byte("selected"))),
+		testIPv4("192.0.2.1", "192.0.2.2", protoUDP, 2, 0,
+			testUDP(40000, 9999, []byte("other"))),
+		testSegment(100, 0x18, "other"),
+	)
+	if len(payloads) != 1 || payloads[0].Protocol != "udp" ||
+		string(payloads[0].Data) != "selected" {
+		t.Errorf("got %d payloads", len(payloads))
+	}
+}
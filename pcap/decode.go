@@ -0,0 +1,305 @@
+//
+// decode.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// IP protocol numbers.
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+// TCP flags.
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+)
+
+// MaxFragments limits the number of pending IP fragments.
+const MaxFragments = 1024
+
+// segment defines a decoded UDP datagram or TCP segment.
+type segment struct {
+	time    time.Time
+	proto   int
+	src     net.IP
+	dst     net.IP
+	sport   int
+	dport   int
+	seq     uint32
+	flags   byte
+	payload []byte
+}
+
+// fragments implements the reassembly of an IP datagram.
+type fragments struct {
+	parts []*fragment
+	total int
+}
+
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// decoder decodes the link layer packets into transport segments.
+type decoder struct {
+	fragments map[string]*fragments
+	order     []string
+}
+
+func newDecoder() *decoder {
+	return &decoder{
+		fragments: make(map[string]*fragments),
+	}
+}
+
+// decode decodes the packet. The function returns nil if the packet
+// is not a UDP or TCP packet, or it is a fragment of an incomplete
+// datagram.
+func (d *decoder) decode(p *Packet) (*segment, error) {
+	data := p.Data
+	var ethType int
+
+	switch p.LinkType {
+	case LinkNull:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated loopback header")
+		}
+		family := binary.LittleEndian.Uint32(data)
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(data)
+		}
+		switch family {
+		case 2:
+			ethType = 0x0800
+		case 24, 28, 30:
+			ethType = 0x86dd
+		}
+		data = data[4:]
+
+	case LinkEthernet:
+		if len(data) < 14 {
+			return nil, fmt.Errorf("truncated Ethernet header")
+		}
+		ethType = int(binary.BigEndian.Uint16(data[12:]))
+		data = data[14:]
+		for ethType == 0x8100 || ethType == 0x88a8 {
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated VLAN header")
+			}
+			ethType = int(binary.BigEndian.Uint16(data[2:]))
+			data = data[4:]
+		}
+
+	case LinkRaw, LinkIPv4, LinkIPv6:
+		if len(data) == 0 {
+			return nil, nil
+		}
+		switch data[0] >> 4 {
+		case 4:
+			ethType = 0x0800
+		case 6:
+			ethType = 0x86dd
+		}
+
+	case LinkLinuxSLL:
+		if len(data) < 16 {
+			return nil, fmt.Errorf("truncated Linux cooked header")
+		}
+		ethType = int(binary.BigEndian.Uint16(data[14:]))
+		data = data[16:]
+
+	case LinkLinuxSLL2:
+		if len(data) < 20 {
+			return nil, fmt.Errorf("truncated Linux cooked header")
+		}
+		ethType = int(binary.BigEndian.Uint16(data))
+		data = data[20:]
+
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", p.LinkType)
+	}
+
+	seg := &segment{
+		time: p.Time,
+	}
+	var err error
+	switch ethType {
+	case 0x0800:
+		data, err = d.ipv4(seg, data)
+	case 0x86dd:
+		data, err = d.ipv6(seg, data)
+	default:
+		return nil, nil
+	}
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	switch seg.proto {
+	case protoUDP:
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated UDP header")
+		}
+		seg.sport = int(binary.BigEndian.Uint16(data))
+		seg.dport = int(binary.BigEndian.Uint16(data[2:]))
+		length := int(binary.BigEndian.Uint16(data[4:]))
+		if length >= 8 && length <= len(data) {
+			data = data[:length]
+		}
+		seg.payload = data[8:]
+
+	case protoTCP:
+		if len(data) < 20 {
+			return nil, fmt.Errorf("truncated TCP header")
+		}
+		seg.sport = int(binary.BigEndian.Uint16(data))
+		seg.dport = int(binary.BigEndian.Uint16(data[2:]))
+		seg.seq = binary.BigEndian.Uint32(data[4:])
+		seg.flags = data[13]
+		hlen := int(data[12]>>4) * 4
+		if hlen < 20 || hlen > len(data) {
+			return nil, fmt.Errorf("invalid TCP header length %d", hlen)
+		}
+		seg.payload = data[hlen:]
+
+	default:
+		return nil, nil
+	}
+	return seg, nil
+}
+
+func (d *decoder) ipv4(seg *segment, data []byte) ([]byte, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("truncated IPv4 header")
+	}
+	hlen := int(data[0]&0x0f) * 4
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if hlen < 20 || length < hlen || length > len(data) {
+		return nil, fmt.Errorf("invalid IPv4 header")
+	}
+	seg.proto = int(data[9])
+	seg.src = net.IP(data[12:16])
+	seg.dst = net.IP(data[16:20])
+
+	frag := binary.BigEndian.Uint16(data[6:])
+	more := frag&0x2000 != 0
+	offset := int(frag&0x1fff) * 8
+	payload := data[hlen:length]
+	if !more && offset == 0 {
+		return payload, nil
+	}
+	key := fmt.Sprintf("%s %s %d %d", seg.src, seg.dst, seg.proto,
+		binary.BigEndian.Uint16(data[4:]))
+	return d.reassemble(key, offset, more, payload), nil
+}
+
+func (d *decoder) ipv6(seg *segment, data []byte) ([]byte, error) {
+	if len(data) < 40 {
+		return nil, fmt.Errorf("truncated IPv6 header")
+	}
+	length := int(binary.BigEndian.Uint16(data[4:]))
+	if 40+length > len(data) {
+		return nil, fmt.Errorf("invalid IPv6 header")
+	}
+	next := int(data[6])
+	seg.src = net.IP(data[8:24])
+	seg.dst = net.IP(data[24:40])
+	data = data[40 : 40+length]
+
+	for {
+		switch next {
+		case 0, 43, 60: // Hop-by-hop, routing, destination options.
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated IPv6 extension header")
+			}
+			hlen := (int(data[1]) + 1) * 8
+			if hlen > len(data) {
+				return nil, fmt.Errorf("truncated IPv6 extension header")
+			}
+			next = int(data[0])
+			data = data[hlen:]
+
+		case 44: // Fragment.
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated IPv6 fragment header")
+			}
+			seg.proto = int(data[0])
+			frag := binary.BigEndian.Uint16(data[2:])
+			more := frag&0x1 != 0
+			offset := int(frag &^ 0x7)
+			key := fmt.Sprintf("%s %s %d %d", seg.src, seg.dst, seg.proto,
+				binary.BigEndian.Uint32(data[4:]))
+			return d.reassemble(key, offset, more, data[8:]), nil
+
+		default:
+			seg.proto = next
+			return data, nil
+		}
+	}
+}
+
+// reassemble adds the fragment to its datagram. The function returns
+// the datagram payload when all its fragments have been received.
+func (d *decoder) reassemble(key string, offset int, more bool,
+	data []byte) []byte {
+
+	f, ok := d.fragments[key]
+	if !ok {
+		if len(d.order) >= MaxFragments {
+			delete(d.fragments, d.order[0])
+			d.order = d.order[1:]
+		}
+		f = new(fragments)
+		d.fragments[key] = f
+		d.order = append(d.order, key)
+	}
+	f.parts = append(f.parts, &fragment{
+		offset: offset,
+		data:   append([]byte(nil), data...),
+	})
+	if !more {
+		f.total = offset + len(data)
+	}
+	if f.total == 0 {
+		return nil
+	}
+	sort.Slice(f.parts, func(i, j int) bool {
+		return f.parts[i].offset < f.parts[j].offset
+	})
+	var result []byte
+	for _, part := range f.parts {
+		if part.offset > len(result) {
+			return nil
+		}
+		if end := part.offset + len(part.data); end > len(result) {
+			result = append(result, part.data[len(result)-part.offset:]...)
+		}
+	}
+	if len(result) < f.total {
+		return nil
+	}
+	delete(d.fragments, key)
+	for i, k := range d.order {
+		if k == key {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+	return result[:f.total]
+}
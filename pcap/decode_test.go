@@ -0,0 +1,232 @@
+//
+// decode_test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func testIPv4(src, dst string, proto byte, id uint16, frag uint16,
+	payload []byte) []byte {
+
+	hdr := make([]byte, 20)
+	hdr[0] = 0x45
+	binary.BigEndian.PutUint16(hdr[2:], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(hdr[4:], id)
+	binary.BigEndian.PutUint16(hdr[6:], frag)
+	hdr[8] = 64
+	hdr[9] = proto
+	copy(hdr[12:], net.ParseIP(src).To4())
+	copy(hdr[16:], net.ParseIP(dst).To4())
+	return append(hdr, payload...)
+}
+
+func testIPv6(src, dst string, next byte, payload []byte) []byte {
+	hdr := make([]byte, 40)
+	hdr[0] = 0x60
+	binary.BigEndian.PutUint16(hdr[4:], uint16(len(payload)))
+	hdr[6] = next
+	hdr[7] = 64
+	copy(hdr[8:], net.ParseIP(src).To16())
+	copy(hdr[24:], net.ParseIP(dst).To16())
+	return append(hdr, payload...)
+}
+
+// testIPv6Fragment creates an IPv6 fragment header and its data.
+func testIPv6Fragment(next byte, id uint32, offset int, more bool,
+	data []byte) []byte {
+
+	hdr := make([]byte, 8)
+	hdr[0] = next
+	frag := uint16(offset)
+	if more {
+		frag |= 1
+	}
+	binary.BigEndian.PutUint16(hdr[2:], frag)
+	binary.BigEndian.PutUint32(hdr[4:], id)
+	return append(hdr, data...)
+}
+
+func testUDP(sport, dport int, data []byte) []byte {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint16(hdr, uint16(sport))
+	binary.BigEndian.PutUint16(hdr[2:], uint16(dport))
+	binary.BigEndian.PutUint16(hdr[4:], uint16(8+len(data)))
+	return append(hdr, data...)
+}
+
+func testTCP(sport, dport int, seq uint32, flags byte, data []byte) []byte {
+	hdr := make([]byte, 20)
+	binary.BigEndian.PutUint16(hdr, uint16(sport))
+	binary.BigEndian.PutUint16(hdr[2:], uint16(dport))
+	binary.BigEndian.PutUint32(hdr[4:], seq)
+	hdr[12] = 5 << 4
+	hdr[13] = flags
+	return append(hdr, data...)
+}
+
+func testEthernet(ethType uint16, data []byte) []byte {
+	hdr := make([]byte, 14)
+	binary.BigEndian.PutUint16(hdr[12:], ethType)
+	return append(hdr, data...)
+}
+
+var decodeTests = []struct {
+	name     string
+	linkType int
+	data     []byte
+	src      string
+	dport    int
+}{
+	{
+		name:     "ethernet",
+		linkType: LinkEthernet,
+		data: testEthernet(0x0800, testIPv4("192.0.2.1", "192.0.2.2",
+			protoUDP, 1, 0, testUDP(1000, 514, []byte("msg")))),
+		src:   "192.0.2.1",
+		dport: 514,
+	},
+	{
+		name:     "vlan",
+		linkType: LinkEthernet,
+		data: testEthernet(0x8100, append([]byte{0, 1, 0x08, 0x00},
+			testIPv4("192.0.2.1", "192.0.2.2", protoUDP, 1, 0,
+				testUDP(1000, 514, []byte("msg")))...)),
+		src:   "192.0.2.1",
+		dport: 514,
+	},
+	{
+		name:     "loopback",
+		linkType: LinkNull,
+		data: append([]byte{2, 0, 0, 0}, testIPv4("127.0.0.1",
+			"127.0.0.1", protoUDP, 1, 0,
+			testUDP(1000, 514, []byte("msg")))...),
+		src:   "127.0.0.1",
+		dport: 514,
+	},
+	{
+		name:     "loopback ipv6",
+		linkType: LinkNull,
+		data: append([]byte{0, 0, 0, 30}, testIPv6("::1", "::1", protoUDP,
+			testUDP(1000, 514, []byte("msg")))...),
+		src:   "::1",
+		dport: 514,
+	},
+	{
+		name:     "raw",
+		linkType: LinkRaw,
+		data: testIPv6("2001:db8::1", "2001:db8::2", protoTCP,
+			testTCP(1000, 601, 1, 0x18, []byte("msg"))),
+		src:   "2001:db8::1",
+		dport: 601,
+	},
+	{
+		name:     "sll",
+		linkType: LinkLinuxSLL,
+		data: append([]byte{0, 0, 0, 1, 0, 6, 2, 0, 0, 0, 0, 1, 0, 0,
+			0x08, 0x00}, testIPv4("192.0.2.1", "192.0.2.2", protoUDP, 1, 0,
+			testUDP(1000, 514, []byte("msg")))...),
+		src:   "192.0.2.1",
+		dport: 514,
+	},
+	{
+		name:     "sll2",
+		linkType: LinkLinuxSLL2,
+		data: append([]byte{0x86, 0xdd, 0, 0, 0, 0, 0, 2, 0, 1, 0, 6, 2,
+			0, 0, 0, 0, 1, 0, 0}, testIPv6("2001:db8::1", "2001:db8::2",
+			protoUDP, testUDP(1000, 1514, []byte("msg")))...),
+		src:   "2001:db8::1",
+		dport: 1514,
+	},
+}
+
+func TestDecode(t *testing.T) {
+	for _, test := range decodeTests {
+		seg, err := newDecoder().decode(&Packet{
+			LinkType: test.linkType,
+			Data:     test.data,
+		})
+		if err != nil {
+			t.Errorf("%s: decode failed: %s", test.name, err)
+			continue
+		}
+		if seg == nil {
+			t.Errorf("%s: no segment", test.name)
+			continue
+		}
+		if !seg.src.Equal(net.ParseIP(test.src)) || seg.dport != test.dport ||
+			string(seg.payload) != "msg" {
+			t.Errorf("%s: got %s:%d %q", test.name, seg.src, seg.dport,
+				seg.payload)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, test := range decodeTests {
+		_, err := newDecoder().decode(&Packet{
+			LinkType: test.linkType,
+			Data:     test.data[:3],
+		})
+		if err == nil {
+			t.Errorf("%s: truncated packet decoded", test.name)
+		}
+	}
+}
+
+func TestFragments(t *testing.T) {
+	payload := testUDP(1000, 514, bytes.Repeat([]byte("0123456789"), 5))
+
+	ipv4 := [][]byte{
+		testIPv4("192.0.2.1", "192.0.2.2", protoUDP, 7, 3,
+			payload[24:]),
+		testIPv4("192.0.2.1", "192.0.2.2", protoUDP, 7, 0x2000|1,
+			payload[8:24]),
+		testIPv4("192.0.2.1", "192.0.2.2", protoUDP, 7, 0x2000,
+			payload[:16]),
+	}
+	ipv6 := [][]byte{
+		testIPv6("2001:db8::1", "2001:db8::2", 44,
+			testIPv6Fragment(protoUDP, 7, 24, false, payload[24:])),
+		testIPv6("2001:db8::1", "2001:db8::2", 44,
+			testIPv6Fragment(protoUDP, 7, 0, true, payload[:24])),
+	}
+	for _, packets := range [][][]byte{ipv4, ipv6} {
+		d := newDecoder()
+		for i, data := range packets {
+			seg, err := d.decode(&Packet{
+				LinkType: LinkRaw,
+				Data:     data,
+			})
+			if err != nil {
+				t.Fatalf("decode failed: %s", err)
+			}
+			if i < len(packets)-1 {
+				if seg != nil {
+					t.Fatalf("fragment %d: datagram before last fragment", i)
+				}
+				continue
+			}
+			if seg == nil {
+				t.Fatalf("fragments not reassembled")
+			}
+			if !bytes.Equal(seg.payload, payload[8:]) {
+				t.Errorf("got payload %q, expected %q", seg.payload,
+					payload[8:])
+			}
+		}
+		if len(d.fragments) != 0 || len(d.order) != 0 {
+			t.Errorf("%d pending datagrams after reassembly",
+				len(d.fragments))
+		}
+	}
+}
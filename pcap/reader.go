@@ -0,0 +1,321 @@
+//
+// reader.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+// Package pcap reads the transport payloads of network traffic
+// captures. The package reads the pcap and pcapng file formats,
+// decodes the Ethernet, Linux cooked, loopback, and raw IP link
+// layers, reassembles the fragmented IPv4 and IPv6 datagrams, and
+// reassembles the TCP streams.
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// Link types.
+const (
+	LinkNull      = 0
+	LinkEthernet  = 1
+	LinkRaw       = 101
+	LinkLinuxSLL  = 113
+	LinkIPv4      = 228
+	LinkIPv6      = 229
+	LinkLinuxSLL2 = 276
+)
+
+// MaxRecordSize limits the size of the capture file records.
+const MaxRecordSize = 16 * 1024 * 1024
+
+// pcapng block types.
+const (
+	blockSHB = 0x0a0d0d0a
+	blockIDB = 0x00000001
+	blockOPB = 0x00000002
+	blockSPB = 0x00000003
+	blockEPB = 0x00000006
+)
+
+var errTooLarge = errors.New("capture record too large")
+
+// Packet defines a captured link layer packet.
+type Packet struct {
+	Time     time.Time
+	LinkType int
+	Data     []byte
+}
+
+// Reader reads packets from pcap and pcapng files.
+type Reader struct {
+	r          *bufio.Reader
+	order      binary.ByteOrder
+	ng         bool
+	linkType   int
+	resolution uint64
+	interfaces []*iface
+	last       time.Time
+}
+
+// iface defines a pcapng capture interface.
+type iface struct {
+	linkType   int
+	resolution uint64
+	offset     int64
+}
+
+// IsCapture tests if the data starts with a pcap or pcapng file
+// header.
+func IsCapture(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(data) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1, blockSHB:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewReader creates a new capture file reader. The function reads
+// the file header and detects the file format.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		r: bufio.NewReader(r),
+	}
+	magic, err := reader.r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(magic) == blockSHB {
+		reader.ng = true
+		return reader, nil
+	}
+
+	var hdr [24]byte
+	_, err = io.ReadFull(reader.r, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	switch binary.BigEndian.Uint32(hdr[:]) {
+	case 0xa1b2c3d4:
+		reader.order = binary.BigEndian
+		reader.resolution = 1000000
+	case 0xd4c3b2a1:
+		reader.order = binary.LittleEndian
+		reader.resolution = 1000000
+	case 0xa1b23c4d:
+		reader.order = binary.BigEndian
+		reader.resolution = 1000000000
+	case 0x4d3cb2a1:
+		reader.order = binary.LittleEndian
+		reader.resolution = 1000000000
+	default:
+		return nil, fmt.Errorf("unknown capture file format")
+	}
+	reader.linkType = int(reader.order.Uint32(hdr[20:]) & 0xffff)
+	return reader, nil
+}
+
+// Next returns the next packet from the capture file. The function
+// returns io.EOF at the end of the file.
+func (r *Reader) Next() (*Packet, error) {
+	if r.ng {
+		return r.nextBlock()
+	}
+	var hdr [16]byte
+	_, err := io.ReadFull(r.r, hdr[:])
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("truncated packet header")
+		}
+		return nil, err
+	}
+	length := r.order.Uint32(hdr[8:])
+	if length > MaxRecordSize {
+		return nil, errTooLarge
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(r.r, data)
+	if err != nil {
+		return nil, fmt.Errorf("truncated packet: %s", err)
+	}
+	r.last = time.Unix(int64(r.order.Uint32(hdr[0:])),
+		int64(r.order.Uint32(hdr[4:]))*int64(1000000000/r.resolution))
+	return &Packet{
+		Time:     r.last,
+		LinkType: r.linkType,
+		Data:     data,
+	}, nil
+}
+
+// nextBlock reads pcapng blocks until it finds a packet block.
+func (r *Reader) nextBlock() (*Packet, error) {
+	for {
+		var hdr [8]byte
+		_, err := io.ReadFull(r.r, hdr[:])
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = fmt.Errorf("truncated block header")
+			}
+			return nil, err
+		}
+		if binary.BigEndian.Uint32(hdr[:]) == blockSHB {
+			var bom [4]byte
+			_, err = io.ReadFull(r.r, bom[:])
+			if err != nil {
+				return nil, fmt.Errorf("truncated section header: %s", err)
+			}
+			switch binary.BigEndian.Uint32(bom[:]) {
+			case 0x1a2b3c4d:
+				r.order = binary.BigEndian
+			case 0x4d3c2b1a:
+				r.order = binary.LittleEndian
+			default:
+				return nil, fmt.Errorf("invalid section byte order")
+			}
+			r.interfaces = nil
+			_, err = r.readBody(r.order.Uint32(hdr[4:]), 12)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if r.order == nil {
+			return nil, fmt.Errorf("block outside section")
+		}
+		blockType := r.order.Uint32(hdr[:])
+		body, err := r.readBody(r.order.Uint32(hdr[4:]), 8)
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case blockIDB:
+			if len(body) < 8 {
+				return nil, fmt.Errorf("invalid interface block")
+			}
+			ifc := &iface{
+				linkType:   int(r.order.Uint16(body)),
+				resolution: 1000000,
+			}
+			r.options(body[8:], func(code int, val []byte) {
+				switch code {
+				case 9: // if_tsresol
+					if len(val) == 1 && val[0]&0x7f < 64 {
+						ifc.resolution = 1
+						for i := 0; i < int(val[0]&0x7f); i++ {
+							if val[0]&0x80 != 0 {
+								ifc.resolution *= 2
+							} else {
+								ifc.resolution *= 10
+							}
+						}
+					}
+				case 14: // if_tsoffset
+					if len(val) == 8 {
+						ifc.offset = int64(r.order.Uint64(val))
+					}
+				}
+			})
+			r.interfaces = append(r.interfaces, ifc)
+
+		case blockEPB:
+			if len(body) < 20 {
+				return nil, fmt.Errorf("invalid packet block")
+			}
+			return r.packet(r.order.Uint32(body), r.order.Uint32(body[4:]),
+				r.order.Uint32(body[8:]), r.order.Uint32(body[12:]),
+				body[20:])
+
+		case blockOPB:
+			if len(body) < 20 {
+				return nil, fmt.Errorf("invalid packet block")
+			}
+			return r.packet(uint32(r.order.Uint16(body)),
+				r.order.Uint32(body[4:]), r.order.Uint32(body[8:]),
+				r.order.Uint32(body[12:]), body[20:])
+
+		case blockSPB:
+			if len(body) < 4 || len(r.interfaces) == 0 {
+				return nil, fmt.Errorf("invalid simple packet block")
+			}
+			// The simple packet blocks do not have timestamps.
+			// They get the timestamp of the previous packet.
+			data := body[4:]
+			if length := r.order.Uint32(body); int(length) < len(data) {
+				data = data[:length]
+			}
+			return &Packet{
+				Time:     r.last,
+				LinkType: r.interfaces[0].linkType,
+				Data:     data,
+			}, nil
+		}
+	}
+}
+
+// readBody reads the rest of the block body and the trailing block
+// length. The length is the total block length and the offset is the
+// number of the block bytes already read.
+func (r *Reader) readBody(length uint32, offset uint32) ([]byte, error) {
+	if length < offset+4 || length%4 != 0 {
+		return nil, fmt.Errorf("invalid block length %d", length)
+	}
+	if length > MaxRecordSize {
+		return nil, errTooLarge
+	}
+	body := make([]byte, length-offset)
+	_, err := io.ReadFull(r.r, body)
+	if err != nil {
+		return nil, fmt.Errorf("truncated block: %s", err)
+	}
+	return body[:len(body)-4], nil
+}
+
+// options calls the function for each option of the pcapng block.
+func (r *Reader) options(data []byte, fn func(code int, val []byte)) {
+	for len(data) >= 4 {
+		code := int(r.order.Uint16(data))
+		length := int(r.order.Uint16(data[2:]))
+		if code == 0 || 4+length > len(data) {
+			return
+		}
+		fn(code, data[4:4+length])
+		data = data[4+(length+3)&^3:]
+	}
+}
+
+// packet creates a packet from the pcapng packet block fields.
+func (r *Reader) packet(id, high, low, length uint32, data []byte) (
+	*Packet, error) {
+
+	if int(id) >= len(r.interfaces) {
+		return nil, fmt.Errorf("unknown interface %d", id)
+	}
+	ifc := r.interfaces[id]
+	if int(length) < len(data) {
+		data = data[:length]
+	}
+	ts := uint64(high)<<32 | uint64(low)
+	sec := ts / ifc.resolution
+	hi, lo := bits.Mul64(ts%ifc.resolution, 1000000000)
+	nsec, _ := bits.Div64(hi, lo, ifc.resolution)
+
+	r.last = time.Unix(int64(sec)+ifc.offset, int64(nsec))
+	return &Packet{
+		Time:     r.last,
+		LinkType: ifc.linkType,
+		Data:     data,
+	}, nil
+}
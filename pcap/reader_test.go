@@ -0,0 +1,229 @@
+//
+// reader_test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// testPcap creates a classic pcap file with the packets. The nano
+// selects the nanosecond timestamp resolution.
+func testPcap(order binary.ByteOrder, nano bool, linkType int,
+	times []time.Time, packets ...[]byte) []byte {
+
+	var buf bytes.Buffer
+	magic := uint32(0xa1b2c3d4)
+	if nano {
+		magic = 0xa1b23c4d
+	}
+	binary.Write(&buf, order, []uint32{magic})
+	binary.Write(&buf, order, []uint16{2, 4})
+	binary.Write(&buf, order, []uint32{0, 0, 65535, uint32(linkType)})
+	for i, p := range packets {
+		frac := uint32(times[i].Nanosecond() / 1000)
+		if nano {
+			frac = uint32(times[i].Nanosecond())
+		}
+		binary.Write(&buf, order, []uint32{uint32(times[i].Unix()), frac,
+			uint32(len(p)), uint32(len(p))})
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+// testBlock creates a little-endian pcapng block.
+func testBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	var buf bytes.Buffer
+	length := uint32(12 + len(body))
+	binary.Write(&buf, binary.LittleEndian, []uint32{blockType, length})
+	buf.Write(body)
+	binary.Write(&buf, binary.LittleEndian, length)
+	return buf.Bytes()
+}
+
+// testOption creates a little-endian pcapng option.
+func testOption(code uint16, val []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint16{code, uint16(len(val))})
+	buf.Write(val)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+func testSHB() []byte {
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, uint32(0x1a2b3c4d))
+	binary.Write(&body, binary.LittleEndian, []uint16{1, 0})
+	binary.Write(&body, binary.LittleEndian, int64(-1))
+	return testBlock(blockSHB, body.Bytes())
+}
+
+func testIDB(linkType uint16, options ...[]byte) []byte {
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, []uint16{linkType, 0})
+	binary.Write(&body, binary.LittleEndian, uint32(65535))
+	for _, o := range options {
+		body.Write(o)
+	}
+	body.Write(testOption(0, nil))
+	return testBlock(blockIDB, body.Bytes())
+}
+
+func testEPB(id uint32, ts uint64, data []byte) []byte {
+	var body bytes.Buffer
+	binary.Write(&body, binary.LittleEndian, []uint32{id, uint32(ts >> 32),
+		uint32(ts), uint32(len(data)), uint32(len(data))})
+	body.Write(data)
+	return testBlock(blockEPB, body.Bytes())
+}
+
+func TestPcap(t *testing.T) {
+	ts := testTime.Add(123456789)
+	for _, order := range []binary.ByteOrder{
+		binary.LittleEndian, binary.BigEndian,
+	} {
+		for _, nano := range []bool{false, true} {
+			file := testPcap(order, nano, LinkRaw, []time.Time{ts},
+				[]byte("packet"))
+			if !IsCapture(file) {
+				t.Errorf("%s/%v: not a capture", order, nano)
+			}
+			r, err := NewReader(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("%s/%v: NewReader failed: %s", order, nano, err)
+			}
+			p, err := r.Next()
+			if err != nil {
+				t.Fatalf("%s/%v: Next failed: %s", order, nano, err)
+			}
+			expected := ts.Truncate(time.Microsecond)
+			if nano {
+				expected = ts
+			}
+			if !p.Time.Equal(expected) || p.LinkType != LinkRaw ||
+				string(p.Data) != "packet" {
+				t.Errorf("%s/%v: got %s %d %q", order, nano, p.Time,
+					p.LinkType, p.Data)
+			}
+			_, err = r.Next()
+			if err != io.EOF {
+				t.Errorf("%s/%v: got %v, expected EOF", order, nano, err)
+			}
+		}
+	}
+}
+
+func TestPcapTruncated(t *testing.T) {
+	file := testPcap(binary.LittleEndian, false, LinkRaw,
+		[]time.Time{testTime}, []byte("packet"))
+	r, err := NewReader(bytes.NewReader(file[:len(file)-2]))
+	if err != nil {
+		t.Fatalf("NewReader failed: %s", err)
+	}
+	_, err = r.Next()
+	if err == nil || err == io.EOF {
+		t.Errorf("truncated packet read: %v", err)
+	}
+
+	_, err = NewReader(bytes.NewReader([]byte("not a capture file......")))
+	if err == nil {
+		t.Errorf("invalid file header accepted")
+	}
+}
+
+func TestPcapng(t *testing.T) {
+	sec := uint64(testTime.Unix())
+	var offset [8]byte
+	binary.LittleEndian.PutUint64(offset[:], 3600)
+
+	var file []byte
+	file = append(file, testSHB()...)
+	// Default microsecond resolution.
+	file = append(file, testIDB(LinkEthernet)...)
+	// Nanosecond resolution.
+	file = append(file, testIDB(LinkLinuxSLL2,
+		testOption(9, []byte{9}))...)
+	// 2^-10 resolution with a one hour offset.
+	file = append(file, testIDB(LinkRaw, testOption(9, []byte{0x8a}),
+		testOption(14, offset[:]))...)
+	file = append(file, testEPB(0, sec*1000000+123456, []byte("first"))...)
+	file = append(file, testEPB(1, sec*1000000000+123456789,
+		[]byte("second"))...)
+	file = append(file, testEPB(2, sec*1024+512, []byte("third"))...)
+
+	if !IsCapture(file) {
+		t.Errorf("not a capture")
+	}
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewReader failed: %s", err)
+	}
+	expected := []struct {
+		time     time.Time
+		linkType int
+		data     string
+	}{
+		{testTime.Add(123456 * time.Microsecond), LinkEthernet, "first"},
+		{testTime.Add(123456789), LinkLinuxSLL2, "second"},
+		{testTime.Add(time.Hour + 500*time.Millisecond), LinkRaw, "third"},
+	}
+	for _, e := range expected {
+		p, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next failed: %s", err)
+		}
+		if !p.Time.Equal(e.time) || p.LinkType != e.linkType ||
+			string(p.Data) != e.data {
+			t.Errorf("got %s %d %q, expected %s %d %q",
+				p.Time.UTC(), p.LinkType, p.Data, e.time, e.linkType, e.data)
+		}
+	}
+	_, err = r.Next()
+	if err != io.EOF {
+		t.Errorf("got %v, expected EOF", err)
+	}
+}
+
+func TestPcapngInvalid(t *testing.T) {
+	var file []byte
+	file = append(file, testSHB()...)
+	file = append(file, testEPB(0, 0, []byte("packet"))...)
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewReader failed: %s", err)
+	}
+	_, err = r.Next()
+	if err == nil {
+		t.Errorf("packet of unknown interface read")
+	}
+
+	file = append(testSHB(), testIDB(LinkRaw)...)
+	block := testEPB(0, 0, []byte("packet"))
+	binary.LittleEndian.PutUint32(block[4:], 13)
+	file = append(file, block...)
+	r, err = NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("NewReader failed: %s", err)
+	}
+	_, err = r.Next()
+	if err == nil {
+		t.Errorf("invalid block length accepted")
+	}
+}
@@ -0,0 +1,132 @@
+//
+// replay.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markkurossi/lgrep/output"
+	"github.com/markkurossi/lgrep/server"
+	"github.com/markkurossi/lgrep/store"
+)
+
+// runReplay replays log files and syslog traffic captures through
+// the syslog handlers and the init file queries. The query results
+// are printed to the standard output; they are not delivered to the
+// alert outputs.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "Verbose output.")
+	init := fs.String("init", "", "Init file.")
+	handlers := fs.String("handlers", "", "Syslog handler definitions file.")
+	timezones := fs.String("timezones", "",
+		"Syslog source time zones file.")
+	ref := fs.String("ref", "",
+		"Reference RFC 3339 time for inferring the years of timestamps\n"+
+			"(default modification time of the file)")
+	ports := fs.String("ports", "514,601,1514",
+		"Comma-separated syslog UDP and TCP ports of capture files\n"+
+			"(empty for all ports)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lgrep replay [options] FILE...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var refTime time.Time
+	if len(*ref) > 0 {
+		var err error
+		refTime, err = time.Parse(time.RFC3339, *ref)
+		if err != nil {
+			log.Fatalf("Invalid reference time: %s\n", err)
+		}
+	}
+
+	capturePorts := make(map[int]bool)
+	for _, port := range strings.Split(*ports, ",") {
+		port = strings.TrimSpace(port)
+		if len(port) == 0 {
+			continue
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			log.Fatalf("Invalid port '%s'\n", port)
+		}
+		capturePorts[p] = true
+	}
+
+	server := server.New(store.NewMemDB())
+	server.CapturePorts = capturePorts
+	server.Verbose(*verbose)
+	server.Syslog.SetNextID(1)
+
+	if len(*init) > 0 {
+		err := server.Eval(*init)
+		if err != nil {
+			log.Fatalf("Failed to read init file: %s\n", err)
+		}
+	}
+	server.Outputs.SetConfig(new(output.Config))
+
+	if len(*handlers) > 0 {
+		err := server.Syslog.LoadHandlers(*handlers)
+		if err != nil {
+			log.Fatalf("Failed to read handlers file: %s\n", err)
+		}
+	}
+	if len(*timezones) > 0 {
+		err := server.Syslog.LoadTimezones(*timezones)
+		if err != nil {
+			log.Fatalf("Failed to read time zones file: %s\n", err)
+		}
+	}
+
+	for _, file := range fs.Args() {
+		err := replayFile(server, file, refTime)
+		if err != nil {
+			log.Fatalf("Failed to replay %s: %s\n", file, err)
+		}
+	}
+}
+
+func replayFile(server *server.Server, file string, ref time.Time) error {
+	var in io.Reader
+	if file == "-" {
+		in = os.Stdin
+		if ref.IsZero() {
+			ref = time.Now()
+		}
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if ref.IsZero() {
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			ref = fi.ModTime()
+		}
+		in = f
+	}
+	return server.Replay(in, file, ref)
+}
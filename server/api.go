@@ -0,0 +1,456 @@
+//
+// api.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/output"
+	"github.com/markkurossi/lgrep/syslog"
+)
+
+// API limits.
+var (
+	MaxQueryTimeout     = time.Minute
+	DefaultQueryTimeout = 10 * time.Second
+	MaxQueryResults     = 10000
+	DefaultQueryResults = 1000
+	MaxConcurrentQuery  = 4
+	MaxQuerySize        = 64 * 1024
+)
+
+// QueryRequest defines the query API requests. The Query contains
+// datalog rules and a query. The rules are visible only to the query.
+// The Since and Until limit the query to the facts that were added to
+// the database between the times.
+type QueryRequest struct {
+	Query   string    `json:"query"`
+	Since   time.Time `json:"since,omitempty"`
+	Until   time.Time `json:"until,omitempty"`
+	Timeout string    `json:"timeout,omitempty"`
+	Limit   int       `json:"limit,omitempty"`
+	Explain bool      `json:"explain,omitempty"`
+}
+
+// QueryResponse defines the query API responses.
+type QueryResponse struct {
+	Query     string         `json:"query"`
+	Variables []string       `json:"variables"`
+	Results   []*QueryResult `json:"results"`
+	Truncated bool           `json:"truncated,omitempty"`
+	Elapsed   string         `json:"elapsed"`
+	Explain   *Explain       `json:"explain,omitempty"`
+}
+
+// Explain describes how a query is resolved: the rules that the query
+// uses and the number of facts of the predicates that the query
+// depends on.
+type Explain struct {
+	Rules      []string          `json:"rules"`
+	Predicates []*PredicateCount `json:"predicates"`
+}
+
+// PredicateCount defines the number of facts of a predicate.
+type PredicateCount struct {
+	Predicate string `json:"predicate"`
+	Facts     int    `json:"facts"`
+}
+
+// QueryResult defines a query result.
+type QueryResult struct {
+	Fact      string            `json:"fact"`
+	Timestamp time.Time         `json:"timestamp"`
+	Bindings  map[string]string `json:"bindings"`
+}
+
+// queryError defines the query API error responses.
+type queryError struct {
+	Error string `json:"error"`
+}
+
+// ServeAPI serves the HTTP query API at the address. If the address
+// contains a '/' character, it is a Unix socket path. The API does not
+// authenticate its clients so the TCP address must be a loopback
+// address; the Unix socket access is controlled with the file system
+// permissions. The API has the following endpoints:
+//
+//	POST /query       run a QueryRequest
+//	GET  /subscribe   stream the results of the query URL parameter
+//	GET  /predicates  list the predicates and their fact counts
+//	POST /reload      reload the configuration
+func (s *Server) ServeAPI(address string) error {
+	var listener net.Listener
+	var err error
+	if strings.ContainsRune(address, '/') {
+		syslog.RemoveStale(address)
+		listener, err = net.Listen("unix", address)
+	} else {
+		err = checkLoopback(address)
+		if err != nil {
+			return err
+		}
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("API: listening at %s\n", listener.Addr())
+
+	return http.Serve(listener, s.APIHandler())
+}
+
+// checkLoopback checks that the TCP address is a loopback address.
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("API address %s is not a loopback address", address)
+	}
+	return nil
+}
+
+// APIHandler returns the HTTP handler of the query API.
+func (s *Server) APIHandler() http.Handler {
+	sem := make(chan struct{}, MaxConcurrentQuery)
+	subSem := make(chan struct{}, MaxSubscriptionQueries)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apiError(w, http.StatusMethodNotAllowed, "POST required")
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+		default:
+			apiError(w, http.StatusTooManyRequests, "too many queries")
+			return
+		}
+		req := new(QueryRequest)
+		err := json.NewDecoder(io.LimitReader(r.Body, int64(MaxQuerySize))).
+			Decode(req)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		resp, status, err := s.query(r.Context(), req)
+		if err != nil {
+			apiError(w, status, err.Error())
+			return
+		}
+		apiReply(w, resp)
+	})
+	mux.HandleFunc("/subscribe", func(w http.ResponseWriter, r *http.Request) {
+		s.serveSubscribe(w, r, subSem)
+	})
+	mux.HandleFunc("/predicates", func(w http.ResponseWriter, r *http.Request) {
+		counts, err := s.predicates()
+		if err != nil {
+			apiError(w, http.StatusNotImplemented, err.Error())
+			return
+		}
+		apiReply(w, counts)
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apiError(w, http.StatusMethodNotAllowed, "POST required")
+			return
+		}
+		err := s.Reload()
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Reloaded configuration\n")
+		apiReply(w, struct{}{})
+	})
+	return mux
+}
+
+func apiReply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&queryError{
+		Error: msg,
+	})
+}
+
+// query runs the query request. The query is executed without
+// holding the server lock so that it does not block log ingestion.
+func (s *Server) query(ctx context.Context, req *QueryRequest) (
+	*QueryResponse, int, error) {
+
+	timeout := DefaultQueryTimeout
+	if len(req.Timeout) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(req.Timeout)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if timeout <= 0 {
+			return nil, http.StatusBadRequest,
+				fmt.Errorf("invalid timeout %s", req.Timeout)
+		}
+	}
+	if timeout > MaxQueryTimeout {
+		timeout = MaxQueryTimeout
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultQueryResults
+	}
+	if limit > MaxQueryResults {
+		limit = MaxQueryResults
+	}
+
+	rules, query, err := parseQuery(req.Query)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	db := &queryDB{
+		server: s,
+		ctx:    ctx,
+		head:   query.Head,
+		limit:  limit + 1,
+		rules:  make(map[datalog.AtomID][]*datalog.Clause),
+		until:  time.Now().UnixNano(),
+	}
+	if !req.Since.IsZero() {
+		db.since = req.Since.UnixNano()
+	}
+	if !req.Until.IsZero() {
+		db.until = req.Until.UnixNano()
+	}
+	for _, rule := range rules {
+		id := rule.Head.ID()
+		db.rules[id] = append(db.rules[id], rule)
+	}
+
+	start := time.Now()
+	result := datalog.Execute(query.Head, db, nil)
+	if ctx.Err() != nil {
+		return nil, http.StatusServiceUnavailable,
+			fmt.Errorf("query timeout after %s", timeout)
+	}
+
+	resp := &QueryResponse{
+		Query:     query.String(),
+		Variables: make([]string, 0),
+		Results:   make([]*QueryResult, 0),
+		Elapsed:   time.Since(start).String(),
+	}
+	seen := make(map[string]bool)
+	for _, t := range query.Head.Terms {
+		v, ok := t.(*datalog.TermVariable)
+		if ok && v.Symbol.String() != "_" && !seen[v.Symbol.String()] {
+			seen[v.Symbol.String()] = true
+			resp.Variables = append(resp.Variables, v.Symbol.String())
+		}
+	}
+	if req.Explain {
+		resp.Explain = db.explain(query)
+	}
+	for _, r := range result {
+		if len(resp.Results) >= limit {
+			resp.Truncated = true
+			break
+		}
+		resp.Results = append(resp.Results, &QueryResult{
+			Fact:      r.String(),
+			Timestamp: time.Unix(0, r.Timestamp),
+			Bindings:  output.Bindings(query, r),
+		})
+	}
+	return resp, http.StatusOK, nil
+}
+
+// parseQuery parses the query string. The query string contains
+// optional facts and rules, followed by exactly one query.
+func parseQuery(input string) ([]*datalog.Clause, *datalog.Clause, error) {
+	var rules []*datalog.Clause
+	var query *datalog.Clause
+
+	parser := datalog.NewParser("query", strings.NewReader(input))
+	for {
+		clause, clauseType, err := parser.Parse()
+		if err != nil {
+			if err != io.EOF {
+				return nil, nil, err
+			}
+			break
+		}
+		switch clauseType {
+		case datalog.ClauseFact:
+			rules = append(rules, clause)
+
+		case datalog.ClauseQuery:
+			if query != nil {
+				return nil, nil, fmt.Errorf("multiple queries")
+			}
+			query = clause
+
+		default:
+			return nil, nil, fmt.Errorf("unsupported clause: %s%s",
+				clause, clauseType)
+		}
+	}
+	if query == nil {
+		return nil, nil, fmt.Errorf("no query")
+	}
+	return rules, query, nil
+}
+
+// queryDB implements the clause database of the query API and the
+// streaming queries. The database returns the server's facts between
+// the query's time bounds and the query limits, and the query's own
+// rules. The facts from the init files are returned regardless of the
+// time bounds and the query limits. Each Get locks the server only
+// for the database access. When the query context is done, the
+// database returns no clauses so that the query terminates. If the
+// limit is set, the database returns at most limit facts that match
+// the query head so that the queries of large predicates stop at the
+// result limit.
+type queryDB struct {
+	server *Server
+	ctx    context.Context
+	head   *datalog.Atom
+	limit  int
+	rules  map[datalog.AtomID][]*datalog.Clause
+	since  int64
+	until  int64
+}
+
+func (db *queryDB) Add(clause *datalog.Clause) {
+}
+
+func (db *queryDB) Get(atom *datalog.Atom,
+	limits datalog.Predicates) []*datalog.Clause {
+
+	if db.ctx.Err() != nil {
+		return nil
+	}
+	result := append([]*datalog.Clause(nil), db.rules[atom.ID()]...)
+
+	s := db.server
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var facts int
+	add := func(c *datalog.Clause) {
+		if c.IsFact() && atom == db.head && db.limit > 0 {
+			if !atom.Unify(c.Head, datalog.NewBindings()) {
+				return
+			}
+			if facts >= db.limit {
+				return
+			}
+			facts++
+		}
+		result = append(result, c)
+	}
+	for _, c := range s.initFacts[atom.ID()] {
+		add(c)
+	}
+	for _, c := range s.DB.Get(atom, limits) {
+		if c.IsFact() && (s.permanent[c] ||
+			c.Timestamp <= db.since || c.Timestamp > db.until) {
+			continue
+		}
+		add(c)
+	}
+	return result
+}
+
+func (db *queryDB) Sync() {
+}
+
+// explain explains how the query is resolved in the database.
+func (db *queryDB) explain(query *datalog.Clause) *Explain {
+	result := &Explain{
+		Rules:      make([]string, 0),
+		Predicates: make([]*PredicateCount, 0),
+	}
+	seen := make(map[datalog.AtomID]bool)
+	pending := []*datalog.Atom{query.Head}
+
+	for len(pending) > 0 {
+		atom := pending[0]
+		pending = pending[1:]
+		if atom.Flags != 0 || atom.Predicate.IsExpr() || seen[atom.ID()] {
+			continue
+		}
+		seen[atom.ID()] = true
+
+		var facts int
+		for _, c := range db.Get(atom, nil) {
+			if c.IsFact() {
+				facts++
+				continue
+			}
+			result.Rules = append(result.Rules, c.String())
+			pending = append(pending, c.Body...)
+		}
+		result.Predicates = append(result.Predicates, &PredicateCount{
+			Predicate: atom.ID().String(),
+			Facts:     facts,
+		})
+	}
+	return result
+}
+
+// predicates returns the predicates and their fact counts in the
+// server's clause database.
+func (s *Server) predicates() ([]*PredicateCount, error) {
+	db, ok := s.DB.(Evicter)
+	if !ok {
+		return nil, fmt.Errorf("database does not support listing predicates")
+	}
+	s.m.Lock()
+	counts := db.Facts()
+	s.m.Unlock()
+
+	result := make([]*PredicateCount, 0, len(counts))
+	for id, count := range counts {
+		result = append(result, &PredicateCount{
+			Predicate: id.String(),
+			Facts:     count,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Predicate < result[j].Predicate
+	})
+	return result, nil
+}
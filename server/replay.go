@@ -0,0 +1,269 @@
+//
+// replay.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/pcap"
+	"github.com/markkurossi/lgrep/syslog"
+	"github.com/markkurossi/lgrep/wef"
+)
+
+// DefaultCapturePorts returns the default syslog UDP and TCP ports of
+// the replayed capture files.
+func DefaultCapturePorts() map[int]bool {
+	return map[int]bool{
+		514:  true,
+		601:  true,
+		1514: true,
+	}
+}
+
+// SetClock sets the server clock to the time. When the clock is set,
+// the new facts are timestamped with the clock instead of the wall
+// clock time. The clock never moves backwards and each new fact
+// advances it by one nanosecond so that the facts keep their order.
+func (s *Server) SetClock(t time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if n := t.UnixNano() - 1; n > s.clock {
+		s.clock = n
+	}
+}
+
+// stamp timestamps the fact with the server clock if the clock is
+// set and records the latest fact timestamp of the server. The server
+// lock must be held.
+func (s *Server) stamp(clause *datalog.Clause) {
+	if !clause.IsFact() {
+		return
+	}
+	if s.clock != 0 {
+		s.clock++
+		clause.Timestamp = s.clock
+	}
+	if clause.Timestamp > s.latest {
+		s.latest = clause.Timestamp
+	}
+}
+
+// Replay reads the log file and processes its events as if they were
+// received from the network. The file contains syslog messages, log
+// file lines like /var/log/auth.log, WEF XML events, or a pcap or
+// pcapng capture of syslog traffic. Gzip compressed files are
+// decompressed. The server clock follows the event timestamps, or the
+// packet capture times, so that the query results do not depend on
+// the wall clock time. The log file timestamps without a year are
+// resolved relative to the reference time and the captured message
+// timestamps relative to their capture time. The messages that can't
+// be parsed are logged and skipped.
+func (s *Server) Replay(r io.Reader, name string, ref time.Time) error {
+	in := bufio.NewReader(r)
+	magic, _ := in.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		z, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		in = bufio.NewReader(z)
+	}
+	magic, _ = in.Peek(4)
+	if pcap.IsCapture(magic) {
+		return s.replayCapture(in, name)
+	}
+	if isXML(in) {
+		return s.replayWEF(in)
+	}
+	return s.replaySyslog(in, name, ref)
+}
+
+// isXML tests if the input starts with an XML element or declaration.
+// The syslog messages start with the '<' character too but it is
+// followed by the PRI digits.
+func isXML(in *bufio.Reader) bool {
+	data, _ := in.Peek(512)
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) >= 2 && data[0] == '<' && (data[1] < '0' || data[1] > '9')
+}
+
+// replaySyslog replays the syslog messages of the file lines. The
+// lines longer than the maximum message size are logged and skipped.
+func (s *Server) replaySyslog(in *bufio.Reader, name string,
+	ref time.Time) error {
+
+	max := s.Syslog.MaxMessageSize
+
+	var line int
+	var data []byte
+	var tooLong bool
+	for {
+		chunk, err := in.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			return err
+		}
+		if !tooLong {
+			data = append(data, chunk...)
+			// Allow room for the CR LF line terminator.
+			if max > 0 && len(data) > max+2 {
+				tooLong = true
+				data = data[:0]
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(data) > 0 || tooLong {
+			line++
+		}
+		msg := bytes.TrimRight(data, "\r\n")
+		if tooLong || (max > 0 && len(msg) > max) {
+			log.Printf("%s:%d: message too long\n", name, line)
+		} else if len(msg) > 0 {
+			err := s.replayMessage(msg, &syslog.Source{
+				Transport: "file",
+				Listener:  name,
+			}, ref)
+			if err != nil {
+				log.Printf("%s:%d: %s\n", name, line, err)
+			}
+		}
+		data = data[:0]
+		tooLong = false
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// replayCapture replays the syslog messages of the UDP datagrams and
+// TCP streams of the capture. The events get the capture times as
+// their receive times and the packet addresses as their source
+// addresses.
+func (s *Server) replayCapture(in io.Reader, name string) error {
+	capture, err := pcap.NewCapture(in)
+	if err != nil {
+		return err
+	}
+	capture.Ports = s.CapturePorts
+	streams := make(map[string]*captureStream)
+
+	for {
+		p, err := capture.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if p.Protocol == "udp" {
+			err = s.replayMessage(bytes.TrimRight(p.Data, "\r\n\x00"),
+				&syslog.Source{
+					Transport: p.Protocol,
+					Listener:  p.Dst.String(),
+					Addr:      p.Src,
+					Received:  p.Time,
+				}, p.Time)
+			if err != nil {
+				log.Printf("%s: %s: %s\n", name, p.Src, err)
+			}
+			continue
+		}
+		stream, ok := streams[p.Stream]
+		if !ok {
+			if p.Closed {
+				continue
+			}
+			stream = &captureStream{
+				Stream: syslog.NewStream(s.Syslog.MaxMessageSize),
+				src:    p.Src,
+				dst:    p.Dst,
+			}
+			streams[p.Stream] = stream
+		}
+		if p.Closed {
+			stream.Close()
+			delete(streams, p.Stream)
+		} else {
+			stream.Write(p.Data)
+		}
+		for {
+			frame, err := stream.Next()
+			if err != nil {
+				log.Printf("%s: %s: %s\n", name, p.Stream, err)
+				break
+			}
+			if frame == nil {
+				break
+			}
+			err = s.replayMessage(bytes.TrimRight(frame, "\r\n"),
+				&syslog.Source{
+					Transport: p.Protocol,
+					Listener:  stream.dst.String(),
+					Addr:      stream.src,
+					Received:  p.Time,
+				}, p.Time)
+			if err != nil {
+				log.Printf("%s: %s: %s\n", name, stream.src, err)
+			}
+		}
+	}
+}
+
+// captureStream implements a captured TCP syslog stream.
+type captureStream struct {
+	*syslog.Stream
+	src net.Addr
+	dst net.Addr
+}
+
+// replayMessage parses the syslog message and dispatches the event.
+// The timestamps without a year are resolved relative to the
+// reference time. If the source does not specify the receive time,
+// the event timestamp is used as the receive time. The server clock
+// is advanced to the receive time.
+func (s *Server) replayMessage(data []byte, source *syslog.Source,
+	ref time.Time) error {
+
+	event, err := syslog.ParseFile(data)
+	if err != nil {
+		return err
+	}
+	event.Source = source
+	event.ResolveTimestamp(ref, s.Syslog.Location(event))
+	if source.Received.IsZero() {
+		source.Received = event.Timestamp
+	}
+	s.SetClock(source.Received)
+	s.Syslog.Dispatch(event)
+	return nil
+}
+
+func (s *Server) replayWEF(in io.Reader) error {
+	return wef.ReadEvents(in, func(e *wef.Event) error {
+		t, err := e.Time()
+		if err != nil {
+			log.Printf("Event %s: %s\n", e.System.EventRecordID, err)
+		} else {
+			s.SetClock(t)
+		}
+		s.WEF.Process(e)
+		return nil
+	})
+}
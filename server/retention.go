@@ -0,0 +1,279 @@
+//
+// retention.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/directive"
+	"github.com/markkurossi/lgrep/store"
+)
+
+// Fact sources.
+const (
+	SourceSyslog = "syslog"
+	SourceWEF    = "wef"
+)
+
+// Evicter is implemented by the clause databases that can remove
+// facts.
+type Evicter interface {
+	Facts() map[datalog.AtomID]int
+	Evict(id datalog.AtomID, filter store.Filter) int
+}
+
+// Retention defines how long facts are kept in the clause database.
+// The zero values mean no limit.
+type Retention struct {
+	MaxAge   time.Duration
+	MaxCount int
+}
+
+// parseRetention parses the retention directives:
+//
+//	%@ retain SELECTOR [age=DURATION] [count=N]
+//
+// The SELECTOR is a predicate name, a predicate name and arity
+// (name/arity), a fact source (source:syslog or source:wef), or "*"
+// for all facts.
+func parseRetention(file string) (map[string]*Retention, error) {
+	directives, err := directive.ParseFile(file)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*Retention)
+	for _, d := range directives {
+		if d.Name != "retain" {
+			continue
+		}
+		if len(d.Args) < 2 {
+			return nil, d.Errorf("usage: SELECTOR [age=DURATION] [count=N]")
+		}
+		r := new(Retention)
+		for _, arg := range d.Args[1:] {
+			idx := strings.IndexByte(arg, '=')
+			if idx < 0 {
+				return nil, d.Errorf("invalid argument '%s'", arg)
+			}
+			switch arg[:idx] {
+			case "age":
+				r.MaxAge, err = time.ParseDuration(arg[idx+1:])
+				if err == nil && r.MaxAge <= 0 {
+					return nil, d.Errorf("invalid age '%s'", arg[idx+1:])
+				}
+			case "count":
+				r.MaxCount, err = strconv.Atoi(arg[idx+1:])
+				if err == nil && r.MaxCount <= 0 {
+					return nil, d.Errorf("invalid count '%s'", arg[idx+1:])
+				}
+			default:
+				return nil, d.Errorf("unknown argument '%s'", arg)
+			}
+			if err != nil {
+				return nil, d.Errorf("%s", err)
+			}
+		}
+		result[d.Args[0]] = r
+	}
+	return result, nil
+}
+
+// retention returns the retention policy of the predicate. The
+// policies are matched from the most specific to the least specific
+// selector.
+func (s *Server) retention(id datalog.AtomID) *Retention {
+	name := id.Symbol().String()
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		name = unquoted
+	}
+	selectors := []string{fmt.Sprintf("%s/%d", name, id.Arity()), name}
+	if source, ok := s.sources[id]; ok {
+		selectors = append(selectors, "source:"+source)
+	}
+	selectors = append(selectors, "*")
+
+	for _, sel := range selectors {
+		r, ok := s.policies[sel]
+		if ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// RunCompactor runs the compactor that enforces the retention
+// policies with the argument interval.
+func (s *Server) RunCompactor(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		s.Compact()
+	}
+}
+
+// Compact evicts the facts that are beyond their retention policies.
+// The facts are evicted in the order of their event times, see
+// eventTime. The age of the facts is relative to the server clock
+// when replaying logs and otherwise to the current time. The facts
+// from the init files are never evicted. The function returns the
+// number of evicted facts.
+func (s *Server) Compact() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	db, ok := s.DB.(Evicter)
+	if !ok || len(s.policies) == 0 {
+		return 0
+	}
+	now := time.Now()
+	if s.clock != 0 {
+		now = time.Unix(0, s.clock)
+	}
+	refs := s.eventRefs()
+
+	var count int
+	for id, n := range db.Facts() {
+		r := s.retention(id)
+		if r == nil || (r.MaxAge == 0 && (r.MaxCount == 0 || n <= r.MaxCount)) {
+			continue
+		}
+		var cutoff int64
+		if r.MaxAge > 0 {
+			cutoff = now.Add(-r.MaxAge).UnixNano()
+		}
+		source := s.sources[id]
+		count += db.Evict(id, func(facts []*datalog.Clause) []*datalog.Clause {
+			times := make(map[*datalog.Clause]int64)
+			for _, c := range facts {
+				times[c] = eventTime(source, c, refs)
+			}
+			sort.SliceStable(facts, func(i, j int) bool {
+				return times[facts[i]] < times[facts[j]]
+			})
+			var keep []*datalog.Clause
+			var evictable int
+			for _, c := range facts {
+				if !s.permanent[c] {
+					evictable++
+				}
+			}
+			for _, c := range facts {
+				if s.permanent[c] {
+					keep = append(keep, c)
+					continue
+				}
+				if times[c] < cutoff ||
+					(r.MaxCount > 0 && evictable > r.MaxCount) {
+					evictable--
+					continue
+				}
+				keep = append(keep, c)
+			}
+			return keep
+		})
+	}
+	if count > 0 && s.Syslog.Verbose {
+		log.Printf("Compact: evicted %d facts\n", count)
+	}
+	return count
+}
+
+// The term positions of the event times.
+const (
+	syslogTimeTerm = 2
+	syslogRefTerm  = 7
+	wefTimeTerm    = 9
+)
+
+// eventRefs returns the event times of the syslog event references
+// in Unix nanoseconds. The times are read from the syslog_ref facts.
+func (s *Server) eventRefs() map[string]int64 {
+	sym, _ := datalog.Intern("syslog_ref", false)
+	v, _ := datalog.Intern("V", false)
+	var terms []datalog.Term
+	for i := 0; i < 6; i++ {
+		terms = append(terms, datalog.NewTermVariable(v))
+	}
+	result := make(map[string]int64)
+	for _, c := range s.DB.Get(datalog.NewAtom(sym, terms), nil) {
+		ref, ok1 := intTerm(c, 0)
+		t, ok2 := intTerm(c, 1)
+		if ok1 && ok2 {
+			result[strconv.FormatInt(ref, 10)] = t * int64(time.Second)
+		}
+	}
+	return result
+}
+
+// eventTime returns the event time of the fact in Unix nanoseconds.
+// The time is read from the fact's timestamp term: the syslog event
+// facts have the event timestamp as their third term and the WEF
+// event facts as their tenth term. The other syslog facts start with
+// the Ref term of their event and their time is the time of the
+// referenced event. If the fact has no event time, the function
+// returns the time when the fact was added.
+func eventTime(source string, c *datalog.Clause,
+	refs map[string]int64) int64 {
+
+	switch source {
+	case SourceSyslog:
+		if ref, ok := intTerm(c, 0); ok {
+			if t, ok := refs[strconv.FormatInt(ref, 10)]; ok {
+				return t
+			}
+			break
+		}
+		_, ok := intTerm(c, syslogRefTerm)
+		if t, ok2 := intTerm(c, syslogTimeTerm); ok && ok2 {
+			return t * int64(time.Second)
+		}
+
+	case SourceWEF:
+		if t, ok := intTerm(c, wefTimeTerm); ok {
+			return t
+		}
+	}
+	return c.Timestamp
+}
+
+// intTerm returns the integer value of the fact's idx term.
+func intTerm(c *datalog.Clause, idx int) (int64, bool) {
+	if idx >= len(c.Head.Terms) {
+		return 0, false
+	}
+	t, ok := c.Head.Terms[idx].(*datalog.TermConstant)
+	if !ok || t.Stringlike {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(t.Value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// sourceDB tracks the sources of the facts that are added to the
+// server.
+type sourceDB struct {
+	*Server
+	source string
+}
+
+func (db *sourceDB) Add(clause *datalog.Clause) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	db.sources[clause.Head.ID()] = db.source
+	db.stamp(clause)
+	db.DB.Add(clause)
+}
@@ -11,19 +11,48 @@ package server
 import (
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"sync"
 
 	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/output"
 	"github.com/markkurossi/lgrep/syslog"
 	"github.com/markkurossi/lgrep/wef"
 )
 
-// Server implements LGrep server.
+// Server implements LGrep server. The server serializes the clause
+// database access from its concurrent log collectors. The query
+// results are printed to the Results writer.
 type Server struct {
+	m       sync.Mutex
 	DB      datalog.DB
 	Syslog  *syslog.Server
 	WEF     *wef.Server
-	queries []*Query
+	Outputs *output.Dispatcher
+	Results io.Writer
+	// CapturePorts selects the syslog traffic of the replayed capture
+	// files by the UDP and TCP ports. If the CapturePorts is empty,
+	// all UDP and TCP traffic is replayed.
+	CapturePorts map[int]bool
+	queries      []*Query
+	initFiles    []string
+	policies     map[string]*Retention
+	sources      map[datalog.AtomID]string
+	permanent    map[*datalog.Clause]bool
+	initFacts    map[datalog.AtomID][]*datalog.Clause
+	latest       int64
+	clock        int64
+
+	subscriptions map[*subscription]bool
+}
+
+// MarkStore is implemented by the clause databases that persist the
+// query positions. The positions are keyed by the query clauses and
+// by the predicate names and arities.
+type MarkStore interface {
+	Marks(query string) map[string]int64
+	SetMarks(query string, marks map[string]int64) error
 }
 
 // Query implements queries that are matched against log entries.
@@ -35,13 +64,42 @@ type Query struct {
 // New creates a new server instance.
 func New(db datalog.DB) *Server {
 	server := &Server{
-		DB: db,
+		DB:        db,
+		policies:  make(map[string]*Retention),
+		sources:   make(map[datalog.AtomID]string),
+		permanent: make(map[*datalog.Clause]bool),
+		initFacts: make(map[datalog.AtomID][]*datalog.Clause),
+		Outputs:   output.NewDispatcher(),
+		Results:   os.Stdout,
+
+		CapturePorts: DefaultCapturePorts(),
+
+		subscriptions: make(map[*subscription]bool),
+	}
+	server.Syslog = syslog.New(&sourceDB{server, SourceSyslog})
+	if ids, ok := db.(syslog.IDStore); ok {
+		server.Syslog.IDs = ids
 	}
-	server.Syslog = syslog.New(server)
-	server.WEF = wef.New(server)
+	server.WEF = wef.New(&sourceDB{server, SourceWEF})
 	return server
 }
 
+// Close stops the server's alert dispatcher. If the server's clause
+// database implements the io.Closer interface, the database is
+// closed.
+func (s *Server) Close() error {
+	s.Outputs.Close()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	closer, ok := s.DB.(io.Closer)
+	if ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // Verbose sets the verbose output flag.
 func (s *Server) Verbose(verbose bool) {
 	s.Syslog.Verbose = verbose
@@ -50,6 +108,9 @@ func (s *Server) Verbose(verbose bool) {
 
 // Add adds a clause to the server's clause database.
 func (s *Server) Add(clause *datalog.Clause) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.stamp(clause)
 	s.DB.Add(clause)
 }
 
@@ -57,53 +118,211 @@ func (s *Server) Add(clause *datalog.Clause) {
 // specify the query limits.
 func (s *Server) Get(atom *datalog.Atom,
 	limits datalog.Predicates) []*datalog.Clause {
+	s.m.Lock()
+	defer s.m.Unlock()
 	return s.DB.Get(atom, limits)
 }
 
-// Sync executes the queries against the new log entries.
+// Sync commits the new log entries to the server's clause database
+// and executes the queries against them.
 func (s *Server) Sync() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.DB.Sync()
 	s.executeQueries()
 }
 
 // Eval evaluates the argument file. The facts are added to the
 // server's clause database, queries are executed against the
-// database.
+// database. The retention directives of the file set the retention
+// policies of the facts and the output directives bind the query
+// results to alert outputs and throttles. The file is re-evaluated
+// when the server is reloaded.
 func (s *Server) Eval(file string) error {
-	f, err := os.Open(file)
+	clauses, queries, err := parse(file)
+	if err != nil {
+		return err
+	}
+	policies, err := parseRetention(file)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	all := append(clausesOf(s.queries), clausesOf(queries)...)
+	s.m.Unlock()
+
+	config, err := output.Parse(file, all)
+	if err != nil {
+		return err
+	}
+	s.Outputs.AddConfig(config)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.addUnique(clauses)
+	for k, v := range policies {
+		s.policies[k] = v
+	}
+	s.initFiles = append(s.initFiles, file)
+	s.queries = append(s.queries, queries...)
+
+	// Resolve all predicates, referenced by queries.
+	for _, q := range queries {
+		q.Predicates = q.Clause.Predicates(s.DB, 0)
+		s.restoreMarks(q)
+		if s.Syslog.Verbose {
+			fmt.Printf("%s => %s\n", q.Clause, q.Predicates)
+		}
+	}
+
+	return nil
+}
+
+// Reload re-reads the init files and the syslog handler definitions.
+// The new facts and rules of the init files are added to the
+// server's clause database and the init file queries replace the
+// current queries. The queries that did not change keep their
+// positions in the database so they are not re-executed against the
+// old log entries. If any file has errors, the function returns the
+// error and the server keeps its current rules and queries.
+func (s *Server) Reload() error {
+	s.m.Lock()
+	files := s.initFiles
+	s.m.Unlock()
+
+	var clauses []*datalog.Clause
+	var queries []*Query
+	policies := make(map[string]*Retention)
+	config := new(output.Config)
+	for _, file := range files {
+		c, q, err := parse(file)
+		if err != nil {
+			return err
+		}
+		clauses = append(clauses, c...)
+		queries = append(queries, q...)
+	}
+	for _, file := range files {
+		p, err := parseRetention(file)
+		if err != nil {
+			return err
+		}
+		for k, v := range p {
+			policies[k] = v
+		}
+
+		o, err := output.Parse(file, clausesOf(queries))
+		if err != nil {
+			return err
+		}
+		config.Outputs = append(config.Outputs, o.Outputs...)
+		config.Throttles = append(config.Throttles, o.Throttles...)
+		if len(o.DeadLetter) > 0 {
+			config.DeadLetter = o.DeadLetter
+		}
+	}
+	err := s.Syslog.Reload()
 	if err != nil {
 		return err
 	}
+	s.Outputs.SetConfig(config)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.permanent = make(map[*datalog.Clause]bool)
+	s.initFacts = make(map[datalog.AtomID][]*datalog.Clause)
+	s.addUnique(clauses)
+	s.policies = policies
+
+	current := make(map[string]*Query)
+	for _, q := range s.queries {
+		current[q.Clause.String()] = q
+	}
+	for _, q := range queries {
+		q.Predicates = q.Clause.Predicates(s.DB, 0)
+		old, ok := current[q.Clause.String()]
+		if ok {
+			for k, v := range old.Predicates {
+				if _, ok := q.Predicates[k]; ok {
+					q.Predicates[k] = v
+				}
+			}
+		} else {
+			s.restoreMarks(q)
+		}
+	}
+	s.queries = queries
+
+	return nil
+}
+
+// parse parses the facts, rules, and queries of the file.
+func parse(file string) ([]*datalog.Clause, []*Query, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer f.Close()
+
+	var clauses []*datalog.Clause
+	var queries []*Query
+
 	parser := datalog.NewParser(file, f)
 	for {
 		clause, clauseType, err := parser.Parse()
 		if err != nil {
 			if err != io.EOF {
-				return err
+				return nil, nil, err
 			}
 			break
 		}
 		switch clauseType {
 		case datalog.ClauseFact:
-			s.DB.Add(clause)
+			clauses = append(clauses, clause)
 
 		case datalog.ClauseQuery:
-			fmt.Printf("Query: %s%s\n", clause, clauseType)
-			s.queries = append(s.queries, &Query{
+			queries = append(queries, &Query{
 				Clause: clause,
 			})
 		}
 	}
+	return clauses, queries, nil
+}
 
-	// Resolve all predicates, referenced by queries.
-	for _, q := range s.queries {
-		q.Predicates = q.Clause.Predicates(s.DB, 0)
-		if true {
-			fmt.Printf("%s => %s\n", q.Clause, q.Predicates)
-		}
+// clausesOf returns the clauses of the queries.
+func clausesOf(queries []*Query) []*datalog.Clause {
+	var result []*datalog.Clause
+	for _, q := range queries {
+		result = append(result, q.Clause)
 	}
+	return result
+}
 
-	return nil
+// addUnique adds the clauses that are not already in the clause
+// database. The clauses are marked permanent so that they are not
+// evicted from the database, and they are indexed by their predicates
+// in the initFacts.
+func (s *Server) addUnique(clauses []*datalog.Clause) {
+	for _, clause := range clauses {
+		var found *datalog.Clause
+		for _, c := range s.DB.Get(clause.Head, nil) {
+			if c.Equals(clause) {
+				found = c
+				break
+			}
+		}
+		if found == nil {
+			s.DB.Add(clause)
+			found = clause
+		}
+		if !s.permanent[found] {
+			s.permanent[found] = true
+			id := found.Head.ID()
+			s.initFacts[id] = append(s.initFacts[id], found)
+		}
+	}
 }
 
 func (s *Server) executeQueries() {
@@ -115,7 +334,45 @@ func (s *Server) executeQueries() {
 					q.Predicates[k] = r.Timestamp
 				}
 			}
-			fmt.Printf("%s\n", r)
+			if s.Outputs.Emit(q.Clause, r) {
+				fmt.Fprintf(s.Results, "%s\n", r)
+			}
+		}
+		if len(result) > 0 {
+			s.saveMarks(q)
 		}
 	}
+	s.executeSubscriptions()
+}
+
+// restoreMarks restores the query positions from the clause
+// database.
+func (s *Server) restoreMarks(q *Query) {
+	store, ok := s.DB.(MarkStore)
+	if !ok {
+		return
+	}
+	marks := store.Marks(q.Clause.String())
+	for k := range q.Predicates {
+		v, ok := marks[k.String()]
+		if ok {
+			q.Predicates[k] = v
+		}
+	}
+}
+
+// saveMarks saves the query positions into the clause database.
+func (s *Server) saveMarks(q *Query) {
+	store, ok := s.DB.(MarkStore)
+	if !ok {
+		return
+	}
+	marks := make(map[string]int64)
+	for k, v := range q.Predicates {
+		marks[k.String()] = v
+	}
+	err := store.SetMarks(q.Clause.String(), marks)
+	if err != nil {
+		log.Printf("Failed to save query positions: %s\n", err)
+	}
 }
@@ -0,0 +1,254 @@
+//
+// subscribe.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/output"
+)
+
+// Subscription limits. The MaxSubscriptions limits the number of open
+// subscriptions and the MaxSubscriptionQueries the number of
+// concurrently executing subscription queries. Each subscription
+// query execution is bounded by the SubscriptionTimeout and it
+// returns at most SubscriptionResults results. The results beyond the
+// limit are counted as dropped.
+var (
+	MaxSubscriptions       = 64
+	MaxSubscriptionQueries = 4
+	SubscriptionBufferSize = 256
+	SubscriptionKeepalive  = 30 * time.Second
+	SubscriptionTimeout    = DefaultQueryTimeout
+	SubscriptionResults    = DefaultQueryResults
+)
+
+// subscription implements a streaming query. The query results are
+// sent to the subscriber's buffered channel. If the subscriber does
+// not keep up and the channel is full, the results are dropped and
+// counted.
+type subscription struct {
+	query   *Query
+	rules   map[datalog.AtomID][]*datalog.Clause
+	ch      chan *QueryResult
+	wake    chan struct{}
+	dropped int64
+}
+
+// subscribe registers a streaming query. The query returns only the
+// results from the facts that are added after the subscription.
+func (s *Server) subscribe(input string) (*subscription, error) {
+	rules, query, err := parseQuery(input)
+	if err != nil {
+		return nil, err
+	}
+	sub := &subscription{
+		query: &Query{
+			Clause: query,
+		},
+		rules: make(map[datalog.AtomID][]*datalog.Clause),
+		ch:    make(chan *QueryResult, SubscriptionBufferSize),
+		wake:  make(chan struct{}, 1),
+	}
+	for _, rule := range rules {
+		id := rule.Head.ID()
+		sub.rules[id] = append(sub.rules[id], rule)
+	}
+	sub.query.Predicates = query.Predicates(&queryDB{
+		server: s,
+		ctx:    context.Background(),
+		rules:  sub.rules,
+	}, 0)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.subscriptions) >= MaxSubscriptions {
+		return nil, fmt.Errorf("too many subscriptions")
+	}
+	for k := range sub.query.Predicates {
+		sub.query.Predicates[k] = s.latest
+	}
+	s.subscriptions[sub] = true
+
+	return sub, nil
+}
+
+func (s *Server) unsubscribe(sub *subscription) {
+	s.m.Lock()
+	delete(s.subscriptions, sub)
+	s.m.Unlock()
+}
+
+// executeSubscriptions wakes up the streaming queries to execute them
+// against the new log entries. The queries are executed by their
+// subscription goroutines so that they do not block the log
+// ingestion. The server lock must be held.
+func (s *Server) executeSubscriptions() {
+	for sub := range s.subscriptions {
+		select {
+		case sub.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runSubscription executes the subscription query when new log
+// entries are added, until the context is done. The sem limits the
+// number of concurrent query executions.
+func (s *Server) runSubscription(ctx context.Context, sub *subscription,
+	sem chan struct{}) {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.wake:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case sem <- struct{}{}:
+		}
+		s.executeSubscription(ctx, sub)
+		<-sem
+	}
+}
+
+// executeSubscription executes the subscription query against the
+// facts that were added after its previous execution. The query is
+// executed without holding the server lock and it is bounded by the
+// SubscriptionTimeout and the SubscriptionResults limits. The
+// subscription positions are advanced even if the query times out so
+// that an expensive query is not re-executed against the same facts.
+func (s *Server) executeSubscription(ctx context.Context,
+	sub *subscription) {
+
+	s.m.Lock()
+	until := s.latest
+	s.m.Unlock()
+
+	q := sub.query
+	var pending bool
+	for _, v := range q.Predicates {
+		if v < until {
+			pending = true
+		}
+	}
+	if !pending {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, SubscriptionTimeout)
+	defer cancel()
+
+	db := &queryDB{
+		server: s,
+		ctx:    ctx,
+		head:   q.Clause.Head,
+		limit:  SubscriptionResults,
+		rules:  sub.rules,
+		until:  until,
+	}
+	result := datalog.Execute(q.Clause.Head, db, q.Predicates)
+	for k, v := range q.Predicates {
+		if until > v {
+			q.Predicates[k] = until
+		}
+	}
+	if ctx.Err() != nil {
+		log.Printf("Subscription %s: query timeout after %s\n",
+			q.Clause, SubscriptionTimeout)
+		return
+	}
+	for i, r := range result {
+		if i >= SubscriptionResults {
+			atomic.AddInt64(&sub.dropped, int64(len(result)-i))
+			break
+		}
+		select {
+		case sub.ch <- &QueryResult{
+			Fact:      r.String(),
+			Timestamp: time.Unix(0, r.Timestamp),
+			Bindings:  output.Bindings(q.Clause, r),
+		}:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+		}
+	}
+}
+
+// serveSubscribe streams the query results as Server-Sent Events. The
+// query is given in the query URL parameter. Each result is sent as a
+// "result" event with a QueryResult JSON object. If results were
+// dropped because the client did not keep up, a "dropped" event tells
+// the number of dropped results. The subscription ends when the
+// client disconnects. The sem limits the number of concurrent
+// subscription query executions.
+func (s *Server) serveSubscribe(w http.ResponseWriter, r *http.Request,
+	sem chan struct{}) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	sub, err := s.subscribe(r.URL.Query().Get("query"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer s.unsubscribe(sub)
+	go s.runSubscription(r.Context(), sub, sem)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, ": subscribed %s?\n\n", sub.query.Clause)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(SubscriptionKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepalive.C:
+			_, err = fmt.Fprintf(w, ": keepalive\n\n")
+
+		case result := <-sub.ch:
+			if dropped := atomic.SwapInt64(&sub.dropped, 0); dropped > 0 {
+				_, err = fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n",
+					dropped)
+			}
+			if err == nil {
+				var data []byte
+				data, err = json.Marshal(result)
+				if err == nil {
+					_, err = fmt.Fprintf(w, "event: result\ndata: %s\n\n", data)
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Subscription %s: %s\n", r.RemoteAddr, err)
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,500 @@
+//
+// shell.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/server"
+)
+
+// client implements the query API client.
+type client struct {
+	base   string
+	http   *http.Client
+	limit  int
+	rules  []string
+	since  string
+	until  string
+	output io.Writer
+}
+
+func newClient(address string) *client {
+	c := &client{
+		base:   "http://" + address,
+		http:   new(http.Client),
+		output: os.Stdout,
+	}
+	if strings.ContainsRune(address, '/') {
+		c.base = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (
+				net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", address)
+			},
+		}
+	}
+	return c
+}
+
+func (c *client) do(method, path string, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	r, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	res, err := c.http.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(res.Body).Decode(&e) == nil && len(e.Error) > 0 {
+			return fmt.Errorf("%s", e.Error)
+		}
+		return fmt.Errorf("HTTP status %s", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+// query runs the query with the client's rules.
+func (c *client) query(query string, explain bool) (
+	*server.QueryResponse, error) {
+
+	req := map[string]interface{}{
+		"query":   strings.Join(append(c.rules, query), "\n"),
+		"explain": explain,
+	}
+	if c.limit > 0 {
+		req["limit"] = c.limit
+	}
+	if len(c.since) > 0 {
+		req["since"] = c.since
+	}
+	if len(c.until) > 0 {
+		req["until"] = c.until
+	}
+	resp := new(server.QueryResponse)
+	err := c.do(http.MethodPost, "/query", req, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *client) predicates() ([]*server.PredicateCount, error) {
+	var result []*server.PredicateCount
+	err := c.do(http.MethodGet, "/predicates", nil, &result)
+	return result, err
+}
+
+// printResults prints the query results as a table. The table columns
+// are the query variables.
+func (c *client) printResults(resp *server.QueryResponse) {
+	if len(resp.Variables) == 0 {
+		for _, r := range resp.Results {
+			fmt.Fprintf(c.output, "%s\n", r.Fact)
+		}
+	} else {
+		widths := make([]int, len(resp.Variables))
+		for i, v := range resp.Variables {
+			widths[i] = utf8.RuneCountInString(v)
+		}
+		for _, r := range resp.Results {
+			for i, v := range resp.Variables {
+				if w := utf8.RuneCountInString(r.Bindings[v]); w > widths[i] {
+					widths[i] = w
+				}
+			}
+		}
+		row := func(values []string) {
+			var line string
+			for i, v := range values {
+				if i > 0 {
+					line += "  "
+				}
+				line += v + strings.Repeat(" ",
+					widths[i]-utf8.RuneCountInString(v))
+			}
+			fmt.Fprintf(c.output, "%s\n", strings.TrimRight(line, " "))
+		}
+		row(resp.Variables)
+		var sep []string
+		for _, w := range widths {
+			sep = append(sep, strings.Repeat("-", w))
+		}
+		row(sep)
+		for _, r := range resp.Results {
+			var values []string
+			for _, v := range resp.Variables {
+				values = append(values, r.Bindings[v])
+			}
+			row(values)
+		}
+	}
+	fmt.Fprintf(c.output, "(%d results", len(resp.Results))
+	if resp.Truncated {
+		fmt.Fprintf(c.output, ", truncated")
+	}
+	fmt.Fprintf(c.output, ", %s)\n", resp.Elapsed)
+}
+
+func (c *client) printExplain(resp *server.QueryResponse) {
+	if resp.Explain == nil {
+		return
+	}
+	fmt.Fprintf(c.output, "Query: %s?\n", resp.Query)
+	if len(resp.Explain.Rules) > 0 {
+		fmt.Fprintf(c.output, "Rules:\n")
+		for _, r := range resp.Explain.Rules {
+			fmt.Fprintf(c.output, "  %s.\n", r)
+		}
+	}
+	fmt.Fprintf(c.output, "Predicates:\n")
+	for _, p := range resp.Explain.Predicates {
+		fmt.Fprintf(c.output, "  %-32s %d facts\n", p.Predicate, p.Facts)
+	}
+}
+
+// facts prints the facts of the predicate. If the predicate is empty,
+// the function lists all predicates.
+func (c *client) facts(predicate string) error {
+	counts, err := c.predicates()
+	if err != nil {
+		return err
+	}
+	if len(predicate) == 0 {
+		for _, p := range counts {
+			fmt.Fprintf(c.output, "%-32s %d facts\n", p.Predicate, p.Facts)
+		}
+		return nil
+	}
+	var found bool
+	for _, p := range counts {
+		idx := strings.LastIndexByte(p.Predicate, '/')
+		if idx < 0 {
+			continue
+		}
+		name := p.Predicate[:idx]
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+		if name != predicate && p.Predicate != predicate {
+			continue
+		}
+		arity, err := strconv.Atoi(p.Predicate[idx+1:])
+		if err != nil {
+			continue
+		}
+		found = true
+
+		var vars []string
+		for i := 0; i < arity; i++ {
+			vars = append(vars, fmt.Sprintf("A%d", i+1))
+		}
+		query := p.Predicate[:idx]
+		if arity > 0 {
+			query += "(" + strings.Join(vars, ", ") + ")"
+		}
+		resp, err := c.query(query+"?", false)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.output, "%s:\n", p.Predicate)
+		for _, r := range resp.Results {
+			fmt.Fprintf(c.output, "  %s.\n", r.Fact)
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown predicate '%s'", predicate)
+	}
+	return nil
+}
+
+// shellHelp defines the shell help text.
+const shellHelp = `Enter datalog clauses. Clauses ending with '.' define session rules
+and clauses ending with '?' run queries. Clauses can span multiple
+lines. Commands:
+  :facts [PREDICATE]  list predicates or the facts of the predicate
+  :explain QUERY      explain the query
+  :rules              list session rules
+  :clear              clear session rules
+  :limit N            set the result limit
+  :since [TIME]       limit queries to facts added after RFC 3339 TIME
+  :until [TIME]       limit queries to facts added before RFC 3339 TIME
+  :history            list history
+  !N, !!              re-run history entry N or the last entry
+  :help               print this help
+  :quit               exit the shell
+`
+
+// shell runs the interactive query shell.
+func (c *client) shell(in io.Reader) {
+	history := loadHistory()
+	scanner := bufio.NewScanner(in)
+	var buf string
+
+	for {
+		if len(buf) == 0 {
+			fmt.Fprintf(c.output, "lgrep> ")
+		} else {
+			fmt.Fprintf(c.output, "   ...> ")
+		}
+		if !scanner.Scan() {
+			fmt.Fprintln(c.output)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if len(buf) == 0 {
+			if len(line) == 0 || strings.HasPrefix(line, "%") {
+				continue
+			}
+			if line == "!!" || (line[0] == '!' && len(line) > 1) {
+				var idx int
+				if line == "!!" {
+					idx = len(history)
+				} else {
+					var err error
+					idx, err = strconv.Atoi(line[1:])
+					if err != nil {
+						fmt.Fprintf(c.output, "Invalid history entry: %s\n", line)
+						continue
+					}
+				}
+				if idx < 1 || idx > len(history) {
+					fmt.Fprintf(c.output, "No history entry %d\n", idx)
+					continue
+				}
+				line = history[idx-1]
+				fmt.Fprintf(c.output, "%s\n", line)
+			}
+			if strings.HasPrefix(line, ":") {
+				history = appendHistory(history, line)
+				if !c.command(line, history) {
+					return
+				}
+				continue
+			}
+		}
+		if len(buf) > 0 {
+			buf += " "
+		}
+		buf += line
+		if !strings.HasSuffix(buf, ".") && !strings.HasSuffix(buf, "?") {
+			continue
+		}
+		clause := buf
+		buf = ""
+		history = appendHistory(history, clause)
+
+		if strings.HasSuffix(clause, ".") {
+			_, _, err := datalog.NewParser("shell",
+				strings.NewReader(clause)).Parse()
+			if err != nil {
+				fmt.Fprintf(c.output, "Error: %s\n", err)
+				continue
+			}
+			c.rules = append(c.rules, clause)
+			continue
+		}
+		resp, err := c.query(clause, false)
+		if err != nil {
+			fmt.Fprintf(c.output, "Error: %s\n", err)
+			continue
+		}
+		c.printResults(resp)
+	}
+}
+
+// command runs the shell command. It returns false if the shell
+// should exit.
+func (c *client) command(line string, history []string) bool {
+	fields := strings.Fields(line)
+	arg := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+
+	var err error
+	switch fields[0] {
+	case ":quit", ":q", ":exit":
+		return false
+
+	case ":help", ":h":
+		fmt.Fprint(c.output, shellHelp)
+
+	case ":facts":
+		err = c.facts(arg)
+
+	case ":explain":
+		if !strings.HasSuffix(arg, "?") {
+			arg += "?"
+		}
+		var resp *server.QueryResponse
+		resp, err = c.query(arg, true)
+		if err == nil {
+			c.printExplain(resp)
+			c.printResults(resp)
+		}
+
+	case ":rules":
+		for _, r := range c.rules {
+			fmt.Fprintf(c.output, "%s\n", r)
+		}
+
+	case ":clear":
+		c.rules = nil
+
+	case ":limit":
+		var limit int
+		limit, err = strconv.Atoi(arg)
+		if err == nil {
+			c.limit = limit
+		}
+
+	case ":since":
+		c.since = arg
+
+	case ":until":
+		c.until = arg
+
+	case ":history":
+		for i, h := range history {
+			fmt.Fprintf(c.output, "%4d  %s\n", i+1, h)
+		}
+
+	default:
+		err = fmt.Errorf("unknown command '%s', try :help", fields[0])
+	}
+	if err != nil {
+		fmt.Fprintf(c.output, "Error: %s\n", err)
+	}
+	return true
+}
+
+// historyFile returns the shell history file.
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".lgrep_history")
+}
+
+// maxHistory defines the maximum number of history entries.
+const maxHistory = 1000
+
+func loadHistory() []string {
+	file := historyFile()
+	if len(file) == 0 {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var history []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		history = append(history, scanner.Text())
+	}
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	return history
+}
+
+func appendHistory(history []string, entry string) []string {
+	file := historyFile()
+	if len(file) > 0 {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+			0600)
+		if err == nil {
+			fmt.Fprintln(f, entry)
+			f.Close()
+		}
+	}
+	return append(history, entry)
+}
+
+// runClient runs the query and shell subcommands.
+func runClient(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	defaultAPI := os.Getenv("LGREP_API")
+	if len(defaultAPI) == 0 {
+		defaultAPI = "localhost:8080"
+	}
+	api := fs.String("api", defaultAPI,
+		"Query API TCP address or Unix socket path.")
+	limit := fs.Int("limit", 0, "Result limit.")
+	since := fs.String("since", "",
+		"Limit to facts added after the RFC 3339 time.")
+	until := fs.String("until", "",
+		"Limit to facts added before the RFC 3339 time.")
+	explain := fs.Bool("explain", false, "Explain the query.")
+	fs.Usage = func() {
+		if cmd == "query" {
+			fmt.Fprintf(fs.Output(), "Usage: lgrep query [options] QUERY\n")
+		} else {
+			fmt.Fprintf(fs.Output(), "Usage: lgrep shell [options]\n")
+		}
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	c := newClient(*api)
+	c.limit = *limit
+	c.since = *since
+	c.until = *until
+
+	if cmd == "shell" {
+		c.shell(os.Stdin)
+		return
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	query := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if !strings.HasSuffix(query, "?") {
+		query += "?"
+	}
+	resp, err := c.query(query, *explain)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lgrep: %s\n", err)
+		os.Exit(1)
+	}
+	c.printExplain(resp)
+	c.printResults(resp)
+}
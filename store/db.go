@@ -0,0 +1,586 @@
+//
+// db.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+// Package store implements persistent datalog clause databases.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markkurossi/datalog"
+)
+
+// MaxSegmentSize defines the size after which the database starts a
+// new segment file.
+var MaxSegmentSize int64 = 64 * 1024 * 1024
+
+const (
+	segmentSuffix = ".seg"
+	marksFile     = "marks.json"
+	idsFile       = "ids"
+)
+
+// DB implements a persistent clause database. The facts are appended
+// to segment files in the database directory and they are indexed in
+// memory by their predicates. The rules are kept only in memory since
+// they are re-read from the init files on startup. Each segment
+// record is a JSON encoded fact, prefixed with its CRC-32 checksum.
+// When the database is opened, the segments are read in order and the
+// first truncated or corrupted record and everything after it is
+// discarded. The segments, where at least half of the facts have been
+// evicted, are compacted by rewriting their remaining facts.
+//
+// The SyncInterval sets the minimum interval between the commits of
+// the segment file to stable storage. If the SyncInterval is 0, the
+// segment file is committed every time the database is synchronized.
+type DB struct {
+	SyncInterval time.Duration
+
+	m        sync.Mutex
+	dir      string
+	clauses  index
+	segments map[int]*segmentInfo
+	location map[*datalog.Clause]int
+	segment  *os.File
+	w        *bufio.Writer
+	seq      int
+	size     int64
+	marks    map[string]map[string]int64
+	nextID   uint64
+	synced   time.Time
+}
+
+// segmentInfo tracks the number of live and evicted facts in a
+// segment.
+type segmentInfo struct {
+	live int
+	dead int
+}
+
+type record struct {
+	Timestamp int64  `json:"ts"`
+	Predicate string `json:"p"`
+	Terms     []term `json:"t"`
+}
+
+type term struct {
+	Value      string `json:"v"`
+	Stringlike bool   `json:"s,omitempty"`
+}
+
+// Open opens the database from the directory. The directory is
+// created if it does not exist.
+func Open(dir string) (*DB, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		dir:      dir,
+		clauses:  make(index),
+		segments: make(map[int]*segmentInfo),
+		location: make(map[*datalog.Clause]int),
+		marks:    make(map[string]map[string]int64),
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	for _, segment := range segments {
+		var seq int
+		_, err = fmt.Sscanf(filepath.Base(segment), "%d", &seq)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment %s", segment)
+		}
+		err = db.load(segment, seq)
+		if err != nil {
+			return nil, err
+		}
+		if seq > db.seq {
+			db.seq = seq
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, marksFile))
+	if err == nil {
+		err = json.Unmarshal(data, &db.marks)
+		if err != nil {
+			log.Printf("store: ignoring corrupted %s: %s\n", marksFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, idsFile))
+	if err == nil {
+		_, err = fmt.Sscanf(string(data), "%d", &db.nextID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", idsFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if db.nextID == 0 {
+		db.nextID = 1
+	}
+
+	err = db.openSegment()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// load reads the facts from the segment file. If the segment ends
+// with a truncated or corrupted record, the segment is truncated to
+// its last valid record.
+func (db *DB) load(segment string, seq int) error {
+	f, err := os.OpenFile(segment, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var ofs int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		var clause *datalog.Clause
+		if err == nil {
+			clause, err = decode(line)
+		}
+		if err != nil {
+			log.Printf("store: %s: truncating at offset %d: %s\n",
+				segment, ofs, err)
+			return f.Truncate(ofs)
+		}
+		db.add(clause, seq)
+		ofs += int64(len(line))
+	}
+}
+
+func (db *DB) openSegment() error {
+	db.seq++
+	f, err := os.OpenFile(segmentName(db.dir, db.seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	db.segment = f
+	db.w = bufio.NewWriter(f)
+	db.size = 0
+	return nil
+}
+
+func segmentName(dir string, seq int) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d%s", seq, segmentSuffix))
+}
+
+func encode(clause *datalog.Clause) ([]byte, error) {
+	rec := &record{
+		Timestamp: clause.Timestamp,
+		Predicate: clause.Head.Predicate.String(),
+	}
+	for _, t := range clause.Head.Terms {
+		c, ok := t.(*datalog.TermConstant)
+		if !ok {
+			return nil, fmt.Errorf("non-constant term %s", t)
+		}
+		rec.Terms = append(rec.Terms, term{
+			Value:      c.Value,
+			Stringlike: c.Stringlike,
+		})
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)), nil
+}
+
+func decode(line []byte) (*datalog.Clause, error) {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	if len(line) < 10 || line[8] != ' ' {
+		return nil, fmt.Errorf("invalid record")
+	}
+	var sum uint32
+	_, err := fmt.Sscanf(string(line[:8]), "%08x", &sum)
+	if err != nil {
+		return nil, err
+	}
+	data := line[9:]
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	rec := new(record)
+	err = json.Unmarshal(data, rec)
+	if err != nil {
+		return nil, err
+	}
+	predicate, err := internPredicate(rec.Predicate)
+	if err != nil {
+		return nil, err
+	}
+	var terms []datalog.Term
+	for _, t := range rec.Terms {
+		terms = append(terms, datalog.NewTermConstant(t.Value, t.Stringlike))
+	}
+	return &datalog.Clause{
+		Timestamp: rec.Timestamp,
+		Head:      datalog.NewAtom(predicate, terms),
+	}, nil
+}
+
+// internPredicate interns the predicate from its string
+// representation. The stringlike predicates are quoted datalog
+// strings.
+func internPredicate(name string) (datalog.Symbol, error) {
+	if !strings.HasPrefix(name, `"`) {
+		sym, _ := datalog.Intern(name, false)
+		return sym, nil
+	}
+	lexer := datalog.NewLexer("", strings.NewReader(name))
+	token, err := lexer.GetToken()
+	if err != nil {
+		return datalog.SymNil, err
+	}
+	if token.Type != datalog.TokenString {
+		return datalog.SymNil, fmt.Errorf("invalid predicate %s", name)
+	}
+	sym, _ := datalog.Intern(token.Value, true)
+	return sym, nil
+}
+
+func (db *DB) add(clause *datalog.Clause, seq int) {
+	db.clauses.add(clause)
+	if !clause.IsFact() {
+		return
+	}
+	info, ok := db.segments[seq]
+	if !ok {
+		info = new(segmentInfo)
+		db.segments[seq] = info
+	}
+	info.live++
+	db.location[clause] = seq
+}
+
+// Add implements the datalog.DB.Add. The facts are written to the
+// current segment file and they are persisted when the database is
+// synchronized.
+func (db *DB) Add(clause *datalog.Clause) {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	if !clause.IsFact() {
+		db.clauses.add(clause)
+		return
+	}
+	data, err := encode(clause)
+	if err != nil {
+		log.Printf("store: %s: %s\n", clause, err)
+		return
+	}
+	_, err = db.w.Write(data)
+	if err != nil {
+		log.Printf("store: write failed: %s\n", err)
+		return
+	}
+	db.size += int64(len(data))
+	db.add(clause, db.seq)
+}
+
+// Get implements the datalog.DB.Get.
+func (db *DB) Get(atom *datalog.Atom,
+	limits datalog.Predicates) []*datalog.Clause {
+
+	db.m.Lock()
+	defer db.m.Unlock()
+	return db.clauses.get(atom, limits)
+}
+
+// Sync implements the datalog.DB.Sync. The function writes all added
+// facts to the segment file and commits the file to stable storage
+// if the SyncInterval has elapsed since the previous commit.
+func (db *DB) Sync() {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	var err error
+	if db.SyncInterval > 0 && time.Since(db.synced) < db.SyncInterval {
+		err = db.w.Flush()
+	} else {
+		err = db.sync()
+	}
+	if err != nil {
+		log.Printf("store: sync failed: %s\n", err)
+		return
+	}
+	if db.size > MaxSegmentSize {
+		err = db.rotate()
+		if err != nil {
+			log.Printf("store: failed to start new segment: %s\n", err)
+		}
+	}
+}
+
+func (db *DB) rotate() error {
+	err := db.segment.Close()
+	if err != nil {
+		return err
+	}
+	return db.openSegment()
+}
+
+func (db *DB) sync() error {
+	err := db.w.Flush()
+	if err != nil {
+		return err
+	}
+	err = db.segment.Sync()
+	if err != nil {
+		return err
+	}
+	db.synced = time.Now()
+	return nil
+}
+
+// Close syncs and closes the database.
+func (db *DB) Close() error {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	err := db.sync()
+	if err != nil {
+		db.segment.Close()
+		return err
+	}
+	return db.segment.Close()
+}
+
+// Marks returns the stored database positions of the query. The
+// positions are keyed by the predicate names and arities.
+func (db *DB) Marks(query string) map[string]int64 {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	result := make(map[string]int64)
+	for k, v := range db.marks[query] {
+		result[k] = v
+	}
+	return result
+}
+
+// SetMarks stores the database positions of the query. The positions
+// are committed to stable storage if they changed.
+func (db *DB) SetMarks(query string, marks map[string]int64) error {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	old := db.marks[query]
+	changed := len(old) != len(marks)
+	for k, v := range marks {
+		if old[k] != v {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	m := make(map[string]int64)
+	for k, v := range marks {
+		m[k] = v
+	}
+	db.marks[query] = m
+
+	data, err := json.Marshal(db.marks)
+	if err != nil {
+		return err
+	}
+	return db.writeFile(marksFile, data)
+}
+
+// ReserveIDs reserves n consecutive event IDs and returns the first
+// reserved ID. The reservation is committed to stable storage so the
+// IDs are not reserved again after the database is reopened.
+func (db *DB) ReserveIDs(n uint64) (uint64, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	first := db.nextID
+	err := db.writeFile(idsFile, []byte(fmt.Sprintf("%d\n", first+n)))
+	if err != nil {
+		return 0, err
+	}
+	db.nextID = first + n
+	return first, nil
+}
+
+// writeFile replaces the database file atomically with the data.
+func (db *DB) writeFile(name string, data []byte) error {
+	tmp := filepath.Join(db.dir, name+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(db.dir, name))
+}
+
+// Facts returns the number of facts for each predicate.
+func (db *DB) Facts() map[datalog.AtomID]int {
+	db.m.Lock()
+	defer db.m.Unlock()
+	return db.clauses.facts()
+}
+
+// Evict removes the facts of the predicate that the filter does not
+// keep. The function returns the number of removed facts. The
+// segments, where at least half of the facts have been evicted, are
+// compacted.
+func (db *DB) Evict(id datalog.AtomID, filter Filter) int {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	evicted := db.clauses.evict(id, filter)
+	for _, c := range evicted {
+		seq := db.location[c]
+		delete(db.location, c)
+		info := db.segments[seq]
+		info.live--
+		info.dead++
+	}
+	if len(evicted) > 0 {
+		err := db.compact()
+		if err != nil {
+			log.Printf("store: compaction failed: %s\n", err)
+		}
+	}
+	return len(evicted)
+}
+
+func (db *DB) compact() error {
+	info, ok := db.segments[db.seq]
+	if ok && info.dead > 0 && info.dead >= info.live {
+		err := db.sync()
+		if err != nil {
+			return err
+		}
+		err = db.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	for seq, info := range db.segments {
+		if seq == db.seq || info.dead == 0 || info.dead < info.live {
+			continue
+		}
+		err := db.rewrite(seq)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrite rewrites the segment with its live facts. The new segment
+// replaces the old one atomically so the facts are not lost if the
+// rewrite is interrupted.
+func (db *DB) rewrite(seq int) error {
+	name := segmentName(db.dir, seq)
+	info := db.segments[seq]
+	if info.live == 0 {
+		delete(db.segments, seq)
+		return os.Remove(name)
+	}
+
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, id := range db.predicates() {
+		for _, c := range db.clauses[id] {
+			if !c.IsFact() || db.location[c] != seq {
+				continue
+			}
+			data, err := encode(c)
+			if err == nil {
+				_, err = w.Write(data)
+			}
+			if err != nil {
+				f.Close()
+				os.Remove(tmp)
+				return err
+			}
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, name)
+	if err != nil {
+		return err
+	}
+	info.dead = 0
+	return nil
+}
+
+// predicates returns the predicates of the clause index sorted by
+// their names and arities.
+func (db *DB) predicates() []datalog.AtomID {
+	var result []datalog.AtomID
+	for id := range db.clauses {
+		result = append(result, id)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ni := result[i].Symbol().String()
+		nj := result[j].Symbol().String()
+		if ni != nj {
+			return ni < nj
+		}
+		return result[i].Arity() < result[j].Arity()
+	})
+	return result
+}
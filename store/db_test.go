@@ -0,0 +1,148 @@
+//
+// db_test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/markkurossi/datalog"
+)
+
+var testPredicate, _ = datalog.Intern("store_test", false)
+
+func testFact(i int) *datalog.Clause {
+	return &datalog.Clause{
+		Timestamp: int64(i + 1),
+		Head: datalog.NewAtom(testPredicate, []datalog.Term{
+			datalog.NewTermConstant(fmt.Sprintf("%d", i), false),
+			datalog.NewTermConstant(fmt.Sprintf("value %d", i), true),
+		}),
+	}
+}
+
+// testFacts returns the facts of the test predicate.
+func testFacts(db *DB) []*datalog.Clause {
+	return db.Get(testFact(0).Head, nil)
+}
+
+// writeSegment creates a database with count facts and returns the
+// database directory and its segment file.
+func writeSegment(t *testing.T, count int) (string, string) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		db.Add(testFact(i))
+	}
+	db.Sync()
+	err = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, segmentName(dir, 1)
+}
+
+func reopen(t *testing.T, dir string, expected int) {
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	defer db.Close()
+
+	facts := testFacts(db)
+	if len(facts) != expected {
+		t.Fatalf("got %d facts, expected %d", len(facts), expected)
+	}
+	for i, f := range facts {
+		if !f.Equals(testFact(i)) || f.Timestamp != int64(i+1) {
+			t.Errorf("fact %d: got %s, expected %s", i, f, testFact(i))
+		}
+	}
+
+	// The new facts must be readable after the recovered segment.
+	db.Add(testFact(expected))
+	db.Sync()
+	db.Close()
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	facts = testFacts(db)
+	if len(facts) != expected+1 {
+		t.Fatalf("got %d facts after recovery, expected %d",
+			len(facts), expected+1)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir, _ := writeSegment(t, 5)
+	defer os.RemoveAll(dir)
+
+	reopen(t, dir, 5)
+}
+
+func TestTruncatedSegment(t *testing.T) {
+	dir, segment := writeSegment(t, 5)
+	defer os.RemoveAll(dir)
+
+	fi, err := os.Stat(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Truncate(segment, fi.Size()-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopen(t, dir, 4)
+}
+
+func TestCorruptSegment(t *testing.T) {
+	dir, segment := writeSegment(t, 5)
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the value of the third record.
+	var line int
+	for i := range data {
+		if data[i] == '\n' {
+			line++
+			if line == 2 {
+				data[i+20] ^= 0x01
+				break
+			}
+		}
+	}
+	err = ioutil.WriteFile(segment, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopen(t, dir, 2)
+
+	// The corrupted record and the records after it are discarded.
+	data, err = ioutil.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte{'\n'}); n != 2 {
+		t.Errorf("got %d records, expected 2", n)
+	}
+}
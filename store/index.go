@@ -0,0 +1,84 @@
+//
+// index.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package store
+
+import (
+	"github.com/markkurossi/datalog"
+)
+
+// Filter selects the facts that are kept in the database. The facts
+// are passed to the filter in the order they were added to the
+// database and the filter returns the facts to keep.
+type Filter func(facts []*datalog.Clause) []*datalog.Clause
+
+// index implements the in-memory predicate index of the clause
+// databases.
+type index map[datalog.AtomID][]*datalog.Clause
+
+func (idx index) add(clause *datalog.Clause) {
+	id := clause.Head.ID()
+	idx[id] = append(idx[id], clause)
+}
+
+func (idx index) get(atom *datalog.Atom,
+	limits datalog.Predicates) []*datalog.Clause {
+
+	var result []*datalog.Clause
+	for _, c := range idx[atom.ID()] {
+		if !c.IsFact() || c.Timestamp > limits[atom.ID()] {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// facts returns the number of facts for each predicate.
+func (idx index) facts() map[datalog.AtomID]int {
+	result := make(map[datalog.AtomID]int)
+	for id, clauses := range idx {
+		for _, c := range clauses {
+			if c.IsFact() {
+				result[id]++
+			}
+		}
+	}
+	return result
+}
+
+// evict removes the facts of the predicate that the filter does not
+// keep. The function returns the removed facts.
+func (idx index) evict(id datalog.AtomID, filter Filter) []*datalog.Clause {
+	var rules, facts []*datalog.Clause
+	for _, c := range idx[id] {
+		if c.IsFact() {
+			facts = append(facts, c)
+		} else {
+			rules = append(rules, c)
+		}
+	}
+	keep := make(map[*datalog.Clause]bool)
+	for _, c := range filter(facts) {
+		keep[c] = true
+	}
+
+	var evicted []*datalog.Clause
+	for _, c := range facts {
+		if keep[c] {
+			rules = append(rules, c)
+		} else {
+			evicted = append(evicted, c)
+		}
+	}
+	if len(rules) == 0 {
+		delete(idx, id)
+	} else {
+		idx[id] = rules
+	}
+	return evicted
+}
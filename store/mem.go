@@ -0,0 +1,64 @@
+//
+// mem.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package store
+
+import (
+	"sync"
+
+	"github.com/markkurossi/datalog"
+)
+
+// MemDB implements an in-memory clause database. Unlike the
+// datalog.MemDB, the database supports removing facts.
+type MemDB struct {
+	m       sync.Mutex
+	clauses index
+}
+
+// NewMemDB creates a new in-memory clause database.
+func NewMemDB() *MemDB {
+	return &MemDB{
+		clauses: make(index),
+	}
+}
+
+// Add implements the datalog.DB.Add.
+func (db *MemDB) Add(clause *datalog.Clause) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	db.clauses.add(clause)
+}
+
+// Get implements the datalog.DB.Get.
+func (db *MemDB) Get(atom *datalog.Atom,
+	limits datalog.Predicates) []*datalog.Clause {
+
+	db.m.Lock()
+	defer db.m.Unlock()
+	return db.clauses.get(atom, limits)
+}
+
+// Sync implements the datalog.DB.Sync.
+func (db *MemDB) Sync() {
+}
+
+// Facts returns the number of facts for each predicate.
+func (db *MemDB) Facts() map[datalog.AtomID]int {
+	db.m.Lock()
+	defer db.m.Unlock()
+	return db.clauses.facts()
+}
+
+// Evict removes the facts of the predicate that the filter does not
+// keep. The function returns the number of removed facts.
+func (db *MemDB) Evict(id datalog.AtomID, filter Filter) int {
+	db.m.Lock()
+	defer db.m.Unlock()
+	return len(db.clauses.evict(id, filter))
+}
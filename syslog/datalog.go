@@ -9,26 +9,139 @@
 package syslog
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 
 	"github.com/markkurossi/datalog"
 )
 
-// EventTerms creates datalog terms from the syslog event.
+// EventTerms creates datalog terms from the syslog event. The terms
+// are the event's facility, severity, timestamp, hostname, ident, pid,
+// message, and the Ref term that links the event to its companion
+// facts.
 func EventTerms(e *Event) []datalog.Term {
 	var terms []datalog.Term
 
 	terms = append(terms, shared(fmt.Sprintf("%s", e.Facility), false))
 	terms = append(terms, shared(fmt.Sprintf("%s", e.Severity), false))
-	terms = append(terms, shared(fmt.Sprintf("%d", e.Timestamp.Unix()), false))
+	terms = append(terms, timestamp(e))
 	terms = append(terms, datalog.NewTermConstant(e.Hostname, true))
 	terms = append(terms, datalog.NewTermConstant(e.Ident, true))
 	terms = append(terms, shared(fmt.Sprintf("%d", e.Pid), false))
 	terms = append(terms, datalog.NewTermConstant(e.Message, true))
+	terms = append(terms, RefTerm(e))
 
 	return terms
 }
 
+// RefTerm creates a datalog term that references the syslog event
+// from its companion facts.
+func RefTerm(e *Event) datalog.Term {
+	return datalog.NewTermConstant(e.ID, false)
+}
+
+// EventFacts adds the companion facts of the syslog event into the
+// clause database. The companion facts are linked to the event with
+// the event's Ref term. The syslog_ref fact has the event's RFC 5424
+// MSGID:
+//
+//	syslog_ref(Ref, Timestamp, Hostname, Ident, Pid, MsgID)
+//	syslog_source(Ref, Transport, Listener, PeerAddr, PeerIP, Received)
+//	syslog_sd_id(Ref, SDID)
+//	syslog_sd(Ref, SDID, Name, Value)
+//	syslog_tls_peer(Ref, Subject, Fingerprint)
+//	syslog_peercred(Ref, Pid, UID, GID)
+func EventFacts(e *Event, db datalog.DB, verbose bool) {
+	var cert *x509.Certificate
+	var cred *Cred
+	if e.Source != nil {
+		cert = e.Source.Certificate
+		cred = e.Source.Cred
+	}
+	if len(e.Data) == 0 && e.Source == nil {
+		return
+	}
+	ref := RefTerm(e)
+
+	fact(db, "syslog_ref", []datalog.Term{
+		ref,
+		timestamp(e),
+		datalog.NewTermConstant(e.Hostname, true),
+		datalog.NewTermConstant(e.Ident, true),
+		shared(fmt.Sprintf("%d", e.Pid), false),
+		datalog.NewTermConstant(e.MsgID, true),
+	}, verbose)
+
+	if e.Source != nil {
+		var addr, ip string
+		if e.Source.Addr != nil {
+			addr = e.Source.Addr.String()
+			ip = addrIP(e.Source.Addr)
+		}
+		fact(db, "syslog_source", []datalog.Term{
+			ref,
+			shared(e.Source.Transport, false),
+			shared(e.Source.Listener, true),
+			datalog.NewTermConstant(addr, true),
+			shared(ip, true),
+			datalog.NewTermConstant(
+				fmt.Sprintf("%d", e.Source.Received.Unix()), false),
+		}, verbose)
+	}
+
+	for _, element := range e.Data {
+		id := shared(element.ID, true)
+		fact(db, "syslog_sd_id", []datalog.Term{ref, id}, verbose)
+		for _, param := range element.Params {
+			fact(db, "syslog_sd", []datalog.Term{
+				ref,
+				id,
+				shared(param.Name, true),
+				datalog.NewTermConstant(param.Value, true),
+			}, verbose)
+		}
+	}
+
+	if cert != nil {
+		fact(db, "syslog_tls_peer", []datalog.Term{
+			ref,
+			shared(cert.Subject.String(), true),
+			shared(Fingerprint(cert), true),
+		}, verbose)
+	}
+	if cred != nil {
+		fact(db, "syslog_peercred", []datalog.Term{
+			ref,
+			datalog.NewTermConstant(fmt.Sprintf("%d", cred.Pid), false),
+			shared(fmt.Sprintf("%d", cred.UID), false),
+			shared(fmt.Sprintf("%d", cred.GID), false),
+		}, verbose)
+	}
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "SHA256:" + hex.EncodeToString(sum[:])
+}
+
+func fact(db datalog.DB, predicate string, terms []datalog.Term,
+	verbose bool) {
+
+	sym, _ := datalog.Intern(predicate, false)
+	clause := datalog.NewClause(datalog.NewAtom(sym, terms), nil)
+	if verbose {
+		fmt.Printf("%s.\n", clause)
+	}
+	db.Add(clause)
+}
+
+func timestamp(e *Event) datalog.Term {
+	return shared(fmt.Sprintf("%d", e.Timestamp.Unix()), false)
+}
+
 func shared(val string, stringlike bool) datalog.Term {
 	_, str := datalog.Intern(val, stringlike)
 	return datalog.NewTermConstant(str, stringlike)
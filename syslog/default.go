@@ -14,8 +14,9 @@ import (
 	"github.com/markkurossi/datalog"
 )
 
-// Default implements the default syslog event handler.
-func Default(e *Event, db datalog.DB, verbose bool) {
+// Default implements the default syslog event handler. The handler
+// recognizes all events.
+func Default(e *Event, db datalog.DB, verbose bool) bool {
 	var predicate string
 	if len(e.Ident) > 0 {
 		predicate = e.Ident
@@ -30,4 +31,5 @@ func Default(e *Event, db datalog.DB, verbose bool) {
 		fmt.Printf("%s.\n", clause)
 	}
 	db.Add(clause)
+	return true
 }
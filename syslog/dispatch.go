@@ -0,0 +1,216 @@
+//
+// dispatch.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/markkurossi/datalog"
+)
+
+// Pattern matches event idents and hostnames. The pattern
+// specification has one of the following forms:
+//
+//	NAME          matches the NAME exactly
+//	prefix:NAME   matches the strings starting with NAME
+//	glob:GLOB     matches the strings matching the shell glob
+//	regex:REGEXP  matches the strings matching the regular expression
+//
+// The glob wildcards '*' and '?' match any characters, including '/',
+// and the "[...]" classes match the characters of the class. A
+// specification without a form prefix is a glob if it contains the
+// glob wildcards. The regular expressions are not anchored.
+type Pattern struct {
+	Spec   string
+	exact  string
+	prefix string
+	re     *regexp.Regexp
+}
+
+// NewPattern creates a new pattern from the specification.
+func NewPattern(spec string) (*Pattern, error) {
+	p := &Pattern{
+		Spec: spec,
+	}
+	var err error
+	switch {
+	case strings.HasPrefix(spec, "prefix:"):
+		p.prefix = spec[len("prefix:"):]
+		if len(p.prefix) == 0 {
+			return nil, fmt.Errorf("empty prefix pattern")
+		}
+
+	case strings.HasPrefix(spec, "glob:"):
+		p.re, err = globRegexp(spec[len("glob:"):])
+
+	case strings.HasPrefix(spec, "regex:"):
+		p.re, err = regexp.Compile(spec[len("regex:"):])
+
+	case strings.ContainsAny(spec, "*?["):
+		p.re, err = globRegexp(spec)
+
+	default:
+		p.exact = spec
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %s", spec, err)
+	}
+	return p, nil
+}
+
+// MustPattern creates a new pattern from the specification. The
+// function panics if the specification is invalid.
+func MustPattern(spec string) *Pattern {
+	p, err := NewPattern(spec)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Match tests if the pattern matches the string.
+func (p *Pattern) Match(s string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(s)
+
+	case len(p.prefix) > 0:
+		return strings.HasPrefix(s, p.prefix)
+
+	default:
+		return s == p.exact
+	}
+}
+
+func (p *Pattern) String() string {
+	return p.Spec
+}
+
+// globRegexp compiles the shell glob into an anchored regular
+// expression.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteRune('^')
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			sb.WriteString(".*")
+
+		case '?':
+			sb.WriteRune('.')
+
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteRune('[')
+			sb.WriteString(strings.ReplaceAll(class, `\`, `\\`))
+			sb.WriteRune(']')
+			i += end + 1
+
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	sb.WriteRune('$')
+	return regexp.Compile(sb.String())
+}
+
+// Route routes events to a handler. The route matches the events
+// whose ident matches the Ident pattern. The optional Hostname pattern
+// and Facilities further limit the matching events. The Facilities
+// are facility names, like security and mail.
+type Route struct {
+	Ident      *Pattern
+	Hostname   *Pattern
+	Facilities []string
+	Handler    Handler
+}
+
+// NewRoute creates a new route for the ident pattern specification.
+func NewRoute(ident string, handler Handler) (*Route, error) {
+	p, err := NewPattern(ident)
+	if err != nil {
+		return nil, err
+	}
+	return &Route{
+		Ident:   p,
+		Handler: handler,
+	}, nil
+}
+
+// MustRoute creates a new route for the ident pattern specification.
+// The function panics if the specification is invalid.
+func MustRoute(ident string, handler Handler) *Route {
+	r, err := NewRoute(ident, handler)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Match tests if the route matches the event.
+func (r *Route) Match(e *Event) bool {
+	if !r.Ident.Match(e.Ident) {
+		return false
+	}
+	if r.Hostname != nil && !r.Hostname.Match(e.Hostname) {
+		return false
+	}
+	if len(r.Facilities) == 0 {
+		return true
+	}
+	facility := e.Facility.String()
+	for _, f := range r.Facilities {
+		if f == facility {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Route) String() string {
+	result := r.Ident.String()
+	if r.Hostname != nil {
+		result += fmt.Sprintf(" host=%s", r.Hostname)
+	}
+	if len(r.Facilities) > 0 {
+		result += fmt.Sprintf(" facility=%s", strings.Join(r.Facilities, ","))
+	}
+	return result
+}
+
+// isFacility tests if the name is a known facility name.
+func isFacility(name string) bool {
+	for _, n := range facilities {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch passes the event to the handlers of the matching routes
+// in the route order until a handler recognizes the event. If no
+// handler recognizes the event, it is passed to the Default handler.
+func dispatch(routes []*Route, e *Event, db datalog.DB, verbose bool) {
+	for _, r := range routes {
+		if r.Match(e) && r.Handler(e, db, verbose) {
+			return
+		}
+	}
+	Default(e, db, verbose)
+}
@@ -9,24 +9,71 @@
 package syslog
 
 import (
+	"crypto/x509"
 	"fmt"
+	"net"
 	"regexp"
 	"strconv"
 	"time"
 )
 
-var reEvent = regexp.MustCompile(`^<(\d+)>([[:alpha:]]{3} [ 0-9]{2} \S+) (\S+) (.*)$`)
-var reIdent = regexp.MustCompile(`^([^[]+)\[([[:digit:]]+)\]:\s*(.*)$`)
+var reEvent = regexp.MustCompile(`^<(\d+)>([[:alpha:]]{3} [ 0-9]{2} \S+|\d{4}-\d{2}-\d{2}T\S+) (\S+) (.*)$`)
+var reLocalEvent = regexp.MustCompile(`^<(\d+)>([[:alpha:]]{3} [ 0-9]{2} \d{2}:\d{2}:\d{2}\S*) (.*)$`)
+var reFileEvent = regexp.MustCompile(`^([[:alpha:]]{3} [ 0-9]{2} \d{2}:\d{2}:\d{2}\S*|\d{4}-\d{2}-\d{2}T\S+) (\S+) (.*)$`)
+
+// reIdent matches the RFC 3164 TAG and the optional PID of the BSD
+// messages. The TAG is a word without spaces, brackets, and colons,
+// and it is optional in RFC 3164. Since the tags of the messages
+// without the PID, like "kernel:" and "sudo:", cannot be told apart
+// from messages that start with a word and a colon, the latter get
+// the word as their ident.
+var reIdent = regexp.MustCompile(`^([^\s\[:]+)(?:\[([[:digit:]]+)\])?:\s*(.*)$`)
 
 // Event implements syslog events.
 type Event struct {
-	Facility  Facility
-	Severity  Severity
-	Timestamp time.Time
-	Hostname  string
-	Ident     string
-	Pid       int
-	Message   string
+	ID           string
+	Facility     Facility
+	Severity     Severity
+	Version      int
+	Timestamp    time.Time
+	RawTimestamp string
+	Hostname     string
+	Ident        string
+	Pid          int
+	ProcID       string
+	MsgID        string
+	Data         []*SDElement
+	Message      string
+	Source       *Source
+	timestamp    timestampState
+}
+
+// Source describes the transport that delivered the event.
+type Source struct {
+	// Transport names the transport protocol: udp, tcp, tls, relp,
+	// unix, or unixgram. The replayed log file events have the file
+	// transport and the file name as their listener.
+	Transport string
+	// Listener is the local address of the listener that received
+	// the event.
+	Listener string
+	// Addr is the network address of the peer.
+	Addr net.Addr
+	// Received is the collector time when the event was received.
+	Received time.Time
+	// Certificate is the verified TLS client certificate of the
+	// peer or nil if the peer was not authenticated.
+	Certificate *x509.Certificate
+	// Cred is the kernel-verified credentials of the local peer
+	// process or nil if the credentials are not available.
+	Cred *Cred
+}
+
+// Cred defines the credentials of a local peer process.
+type Cred struct {
+	Pid int
+	UID int
+	GID int
 }
 
 func (e *Event) String() string {
@@ -134,51 +181,116 @@ func (s Severity) String() string {
 	return fmt.Sprintf("severity_%d", s)
 }
 
-// Parse parses a syslog event.
+// Parse parses a syslog event. The function detects the event format
+// and parses both RFC 5424 and RFC 3164 (BSD) syslog events. The RFC
+// 3164 timestamps are interpreted in UTC and their year is inferred
+// from the current time. Events without a valid timestamp get the
+// current time as their timestamp.
 func Parse(data []byte) (*Event, error) {
+	event, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	event.ResolveTimestamp(time.Now(), time.UTC)
+	return event, nil
+}
+
+func parse(data []byte) (*Event, error) {
+	if reRFC5424.Match(data) {
+		return parseRFC5424(data)
+	}
+	return parseRFC3164(data)
+}
+
+// ParseLocal parses a local syslog event. The local events, as
+// emitted by syslog(3) to /dev/log, do not have the HOSTNAME field and
+// the function sets the event hostname from the argument hostname.
+// The event timestamps are interpreted in the local time zone.
+func ParseLocal(data []byte, hostname string) (*Event, error) {
+	var event *Event
+	var err error
+
+	if reRFC5424.Match(data) {
+		event, err = parseRFC5424(data)
+	} else {
+		m := reLocalEvent.FindSubmatch(data)
+		if m == nil {
+			return nil, fmt.Errorf("Invalid local event '%s'", string(data))
+		}
+		event, err = newBSDEvent(m[1], m[2], hostname, m[3])
+	}
+	if err != nil {
+		return nil, err
+	}
+	event.ResolveTimestamp(time.Now(), time.Local)
+	return event, nil
+}
+
+// FilePriority defines the priority of the log file lines that do
+// not have the PRI part. The priority is user.notice, as specified in
+// RFC 3164 for relayed messages without PRI.
+const FilePriority = "13"
+
+// ParseFile parses a line of a log file. The lines are either syslog
+// messages, or lines of the files written by syslog daemons, like
+// /var/log/auth.log, that do not have the PRI part. The lines without
+// PRI get the FilePriority. The function does not resolve the event
+// timestamp; the caller must call ResolveTimestamp.
+func ParseFile(data []byte) (*Event, error) {
+	if len(data) > 0 && data[0] == '<' {
+		return parse(data)
+	}
+	m := reFileEvent.FindSubmatch(data)
+	if m == nil {
+		return nil, fmt.Errorf("Invalid log file line '%s'", string(data))
+	}
+	return newBSDEvent([]byte(FilePriority), m[1], string(m[2]), m[3])
+}
+
+func parseRFC3164(data []byte) (*Event, error) {
 	m := reEvent.FindSubmatch(data)
 	if m == nil {
 		return nil, fmt.Errorf("Invalid event '%s'", string(data))
 	}
-	priority, err := strconv.Atoi(string(m[1]))
+	return newBSDEvent(m[1], m[2], string(m[3]), m[4])
+}
+
+func newBSDEvent(pri, ts []byte, hostname string, msg []byte) (*Event, error) {
+	priority, err := strconv.Atoi(string(pri))
 	if err != nil {
 		return nil, err
 	}
 	facility := priority / 8
 	severity := priority % 8
-	// Mon Jan 2 15:04:05 -0700 MST 2006
-	timestamp, err := time.Parse("Jan _2 15:04:05", string(m[2]))
-	if err != nil {
-		return nil, err
-	}
-	now := time.Now()
-	if timestamp.Month() == now.Month() {
-		timestamp = timestamp.AddDate(now.Year(), 0, 0)
-	}
 
 	var ident string
 	var message string
 	var pid int
 
-	mm := reIdent.FindSubmatch(m[4])
+	mm := reIdent.FindSubmatch(msg)
 	if mm == nil {
-		message = string(m[4])
+		message = string(msg)
 	} else {
 		ident = string(mm[1])
 		message = string(mm[3])
-		pid, err = strconv.Atoi(string(mm[2]))
-		if err != nil {
-			return nil, err
+		if len(mm[2]) > 0 {
+			pid, err = strconv.Atoi(string(mm[2]))
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	return &Event{
-		Facility:  Facility(facility),
-		Severity:  Severity(severity),
-		Timestamp: timestamp,
-		Hostname:  string(m[3]),
-		Ident:     ident,
-		Pid:       pid,
-		Message:   message,
-	}, nil
+	event := &Event{
+		Facility:     Facility(facility),
+		Severity:     Severity(severity),
+		RawTimestamp: string(ts),
+		Hostname:     hostname,
+		Ident:        ident,
+		Pid:          pid,
+		Message:      message,
+	}
+	event.parseBSDTimestamp()
+
+	return event, nil
 }
@@ -9,8 +9,119 @@
 package syslog
 
 import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+
 	"github.com/markkurossi/datalog"
 )
 
-// Handler implements a syslog event handler.
-type Handler func(e *Event, db datalog.DB, verbose bool)
+// Handler implements a syslog event handler. The handler returns
+// false if it did not recognize the event. The unrecognized events are
+// passed to the next matching handler.
+type Handler func(e *Event, db datalog.DB, verbose bool) bool
+
+// CaptureType defines the types of the regular expression captures.
+type CaptureType int
+
+// Known capture types.
+const (
+	CaptureString CaptureType = iota
+	CaptureInt
+	CaptureIP
+	CaptureSymbol
+)
+
+var captureTypes = map[string]CaptureType{
+	"string": CaptureString,
+	"int":    CaptureInt,
+	"ip":     CaptureIP,
+	"symbol": CaptureSymbol,
+}
+
+func (t CaptureType) String() string {
+	for k, v := range captureTypes {
+		if v == t {
+			return k
+		}
+	}
+	return fmt.Sprintf("{CaptureType %d}", t)
+}
+
+// match defines a message pattern. The regular expression R matches
+// event messages and its captures are added to the P predicate's
+// event terms. The optional T specifies the capture types; the
+// captures without type are strings.
+type match struct {
+	P string
+	R *regexp.Regexp
+	T []CaptureType
+}
+
+// terms converts the captures into datalog terms. The function
+// returns false if a capture is not valid for its type.
+func (m *match) terms(captures []string) ([]datalog.Term, bool) {
+	var result []datalog.Term
+	for idx, c := range captures {
+		t := CaptureString
+		if idx < len(m.T) {
+			t = m.T[idx]
+		}
+		switch t {
+		case CaptureInt:
+			if _, err := strconv.ParseInt(c, 10, 64); err != nil {
+				return nil, false
+			}
+			result = append(result, datalog.NewTermConstant(c, false))
+
+		case CaptureIP:
+			if net.ParseIP(c) == nil {
+				return nil, false
+			}
+			result = append(result, datalog.NewTermConstant(c, true))
+
+		case CaptureSymbol:
+			result = append(result, shared(c, true))
+
+		default:
+			result = append(result, datalog.NewTermConstant(c, true))
+		}
+	}
+	return result, true
+}
+
+// matchEvent matches the event message against the matches. The
+// first matching match adds its fact to the database. The function
+// returns false if none of the matches matched the message.
+func matchEvent(matches []match, e *Event, db datalog.DB,
+	verbose bool) bool {
+
+	for _, matcher := range matches {
+		m := matcher.R.FindStringSubmatch(e.Message)
+		if m == nil {
+			continue
+		}
+		if matcher.T == nil {
+			event(db, matcher.P, e, m[1:], verbose)
+			return true
+		}
+		terms, ok := matcher.terms(m[1:])
+		if !ok {
+			continue
+		}
+		fact(db, matcher.P, append(EventTerms(e), terms...), verbose)
+		return true
+	}
+	return false
+}
+
+func event(db datalog.DB, predicate string, e *Event, extra []string,
+	verbose bool) {
+
+	terms := EventTerms(e)
+	for _, e := range extra {
+		terms = append(terms, datalog.NewTermConstant(e, true))
+	}
+	fact(db, predicate, terms, verbose)
+}
@@ -0,0 +1,122 @@
+//
+// kernel.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/markkurossi/datalog"
+)
+
+// reUptime matches the kernel uptime prefix of the kernel messages.
+var reUptime = regexp.MustCompile(`^\[\s*\d+\.\d+\]\s*`)
+
+// reNetfilter matches the netfilter LOG and NFLOG messages. The
+// captures are the log prefix and the packet fields.
+var reNetfilter = regexp.MustCompile(`^(.*?)\s*(IN=\S* OUT=.*)$`)
+
+// Kmsg implements the Handler interface for kernel syslog events.
+// The netfilter LOG and NFLOG messages, like:
+//
+//	[UFW BLOCK] IN=eth0 OUT= MAC=... SRC=192.0.2.1 DST=192.0.2.2 LEN=60 ... PROTO=TCP SPT=51234 DPT=22 ... SYN URGP=0
+//
+// add the following facts. The firewall_packet fact has the event
+// terms followed by the listed terms:
+//
+//	firewall_packet(Prefix, In, Out, Src, Dst, Proto, SrcPort, DstPort, Flags)
+//	firewall_field(Ref, Key, Value)
+//	firewall_flag(Ref, Flag)
+//
+// The firewall_field facts have all KEY=VALUE fields of the packet
+// and the firewall_flag facts have the flag fields, like DF and SYN.
+// They are linked to the packet with the event's Ref term.
+// The Flags term has the flags separated by spaces. The numeric
+// values are integers and the missing ports are 0. All other kernel
+// messages add the kernel_message(Text) fact with the event terms
+// followed by the message text without the uptime prefix.
+func Kmsg(e *Event, db datalog.DB, verbose bool) bool {
+	text := reUptime.ReplaceAllString(e.Message, "")
+
+	m := reNetfilter.FindStringSubmatch(text)
+	if m == nil {
+		fact(db, "kernel_message", append(EventTerms(e),
+			datalog.NewTermConstant(text, true)), verbose)
+		return true
+	}
+	ref := RefTerm(e)
+
+	// The transport headers can repeat the IP header keys, like LEN
+	// for UDP. The values map has the IP header values and the
+	// fields have all values.
+	var fields [][]string
+	var flags []string
+	values := make(map[string]string)
+	for _, field := range strings.Fields(m[2]) {
+		if strings.HasPrefix(field, "[") {
+			// ICMP errors quote the original packet in brackets.
+			break
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) == 1 {
+			flags = append(flags, field)
+			continue
+		}
+		fields = append(fields, kv)
+		if _, ok := values[kv[0]]; !ok {
+			values[kv[0]] = kv[1]
+		}
+	}
+
+	port := func(key string) datalog.Term {
+		val, ok := values[key]
+		if !ok || !isNumber(val) {
+			val = "0"
+		}
+		return datalog.NewTermConstant(val, false)
+	}
+	fact(db, "firewall_packet", append(EventTerms(e),
+		shared(m[1], true),
+		shared(values["IN"], true),
+		shared(values["OUT"], true),
+		datalog.NewTermConstant(values["SRC"], true),
+		datalog.NewTermConstant(values["DST"], true),
+		shared(values["PROTO"], true),
+		port("SPT"),
+		port("DPT"),
+		shared(strings.Join(flags, " "), true),
+	), verbose)
+
+	for _, kv := range fields {
+		var value datalog.Term
+		if isNumber(kv[1]) {
+			value = datalog.NewTermConstant(kv[1], false)
+		} else {
+			value = datalog.NewTermConstant(kv[1], true)
+		}
+		fact(db, "firewall_field", []datalog.Term{
+			ref,
+			shared(kv[0], true),
+			value,
+		}, verbose)
+	}
+	for _, flag := range flags {
+		fact(db, "firewall_flag", []datalog.Term{
+			ref,
+			shared(flag, true),
+		}, verbose)
+	}
+	return true
+}
+
+func isNumber(val string) bool {
+	_, err := strconv.ParseInt(val, 10, 64)
+	return err == nil
+}
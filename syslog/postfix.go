@@ -0,0 +1,107 @@
+//
+// postfix.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"regexp"
+
+	"github.com/markkurossi/datalog"
+)
+
+// postfixMatches match the Postfix messages. Most facts are keyed by
+// the queue ID that links the messages of the smtpd, cleanup, qmgr,
+// and delivery agent processes.
+var postfixMatches = []match{
+	// connect from mail.example.com[192.0.2.1]
+	{
+		P: "postfix_connect",
+		R: regexp.MustCompile(`^connect from ([^\[\s]*)\[([^\]]*)\]`),
+	},
+	// disconnect from mail.example.com[192.0.2.1] ehlo=1 mail=1 rcpt=1 data=1 quit=1 commands=5
+	{
+		P: "postfix_disconnect",
+		R: regexp.MustCompile(`^disconnect from ([^\[\s]*)\[([^\]]*)\]`),
+	},
+	// lost connection after AUTH from unknown[192.0.2.1]
+	{
+		P: "postfix_lost_connection",
+		R: regexp.MustCompile(`^lost connection after (\S+) from ([^\[\s]*)\[([^\]]*)\]`),
+	},
+	// NOQUEUE: reject: RCPT from unknown[192.0.2.1]: 554 5.7.1 <x@example.net>: Relay access denied; from=<a@example.org> to=<x@example.net> proto=ESMTP helo=<h>
+	{
+		P: "postfix_reject",
+		R: regexp.MustCompile(`^(\S+): reject: (\S+) from ([^\[\s]*)\[([^\]]*)\](?::\d+)?: (\d{3}) (.*?); from=<([^>]*)>(?: to=<([^>]*)>)?`),
+		T: []CaptureType{CaptureString, CaptureSymbol, CaptureString,
+			CaptureString, CaptureInt},
+	},
+	// warning: unknown[192.0.2.1]: SASL LOGIN authentication failed: UGFzc3dvcmQ6
+	{
+		P: "postfix_sasl_failure",
+		R: regexp.MustCompile(`^warning: ([^\[\s]*)\[([^\]]*)\]: SASL (\S+) authentication failed: ?(.*)$`),
+	},
+	// 3F2A51C0A2B: client=mail.example.com[192.0.2.1], sasl_method=PLAIN, sasl_username=mtr
+	{
+		P: "postfix_client",
+		R: regexp.MustCompile(`^(\S+): client=([^\[\s]*)\[([^\]]*)\](?::\d+)?(?:, sasl_method=([^,]*))?(?:, sasl_username=([^,]*))?`),
+	},
+	// 3F2A51C0A2B: message-id=<20240303120000.3F2A51C0A2B@example.com>
+	{
+		P: "postfix_message_id",
+		R: regexp.MustCompile(`^(\S+): message-id=<?([^>]*)>?$`),
+	},
+	// 3F2A51C0A2B: from=<a@example.org>, size=1234, nrcpt=1 (queue active)
+	{
+		P: "postfix_from",
+		R: regexp.MustCompile(`^(\S+): from=<([^>]*)>, size=(\d+), nrcpt=(\d+)`),
+		T: []CaptureType{CaptureString, CaptureString, CaptureInt, CaptureInt},
+	},
+	// 3F2A51C0A2B: to=<x@example.net>, relay=mx.example.net[198.51.100.1]:25, delay=1.2, delays=0.1/0/0.5/0.6, dsn=2.0.0, status=sent (250 2.0.0 OK)
+	{
+		P: "postfix_delivery",
+		R: regexp.MustCompile(`^(\S+): to=<([^>]*)>,(?: orig_to=<[^>]*>,)? relay=([^,]*), .*?dsn=([^,]*), status=(\S+)(?: \((.*)\))?$`),
+		T: []CaptureType{CaptureString, CaptureString, CaptureString,
+			CaptureString, CaptureSymbol},
+	},
+	// 3F2A51C0A2B: sender non-delivery notification: 4B5C62D0E1F
+	{
+		P: "postfix_bounce",
+		R: regexp.MustCompile(`^(\S+): sender non-delivery notification: (\S+)$`),
+	},
+	// 3F2A51C0A2B: removed
+	{
+		P: "postfix_removed",
+		R: regexp.MustCompile(`^(\S+): removed$`),
+	},
+}
+
+// Postfix implements the Handler interface for the Postfix syslog
+// events. The Postfix processes log with the postfix/SERVICE idents,
+// like postfix/smtpd and postfix/qmgr, and the built-in route passes
+// only the postfix and postfix/SERVICE idents to the handler. The
+// handler adds the following facts with the event terms followed by
+// the listed terms:
+//
+//	postfix_connect(Host, Addr)
+//	postfix_disconnect(Host, Addr)
+//	postfix_lost_connection(Stage, Host, Addr)
+//	postfix_reject(QueueID, Stage, Host, Addr, Code, Reason, From, To)
+//	postfix_sasl_failure(Host, Addr, Method, Reason)
+//	postfix_client(QueueID, Host, Addr, SASLMethod, SASLUser)
+//	postfix_message_id(QueueID, MessageID)
+//	postfix_from(QueueID, From, Size, Nrcpt)
+//	postfix_delivery(QueueID, To, Relay, DSN, Status, Response)
+//	postfix_bounce(QueueID, BounceQueueID)
+//	postfix_removed(QueueID)
+//
+// The QueueID of the rejected messages that were not queued is
+// NOQUEUE. The delivery Status is sent, bounced, deferred, or
+// expired. The handler does not recognize the other events.
+func Postfix(e *Event, db datalog.DB, verbose bool) bool {
+	return matchEvent(postfixMatches, e, db, verbose)
+}
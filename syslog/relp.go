@@ -0,0 +1,248 @@
+//
+// relp.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// RELP commands.
+const (
+	RELPOpen        = "open"
+	RELPClose       = "close"
+	RELPSyslog      = "syslog"
+	RELPRsp         = "rsp"
+	RELPServerClose = "serverclose"
+)
+
+// RELPSoftware identifies the RELP server implementation.
+var RELPSoftware = "lgrep,0.1,https://github.com/markkurossi/lgrep"
+
+// relpFrame implements RELP frames:
+//
+//	TXNR SP COMMAND SP DATALEN [SP DATA] TRAILER
+type relpFrame struct {
+	Txnr    int
+	Command string
+	Data    []byte
+}
+
+func (f *relpFrame) String() string {
+	return fmt.Sprintf("%d %s %d", f.Txnr, f.Command, len(f.Data))
+}
+
+// ServeRELP handles the Reliable Event Logging Protocol (RELP)
+// connections from the specified TCP address. The syslog commands are
+// acknowledged after their events have been committed to the clause
+// database. The messages that can't be parsed are logged and
+// acknowledged since resending them would not help. The acknowledged
+// events are durable only if the clause database commits its facts
+// to stable storage on every Sync; with an in-memory database, the
+// events are lost when the server exits.
+func (s *Server) ServeRELP(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("Syslog RELP: listening at %s\n", listener.Addr())
+
+	return s.serveStream(listener, s.serveRELPConn)
+}
+
+func (s *Server) serveRELPConn(conn net.Conn) {
+	defer conn.Close()
+
+	source, err := s.connSource(conn)
+	if err != nil {
+		log.Printf("RELP %s: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	source.Transport = "relp"
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var open bool
+
+	for {
+		if s.IdleTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(s.IdleTimeout))
+		}
+		frame, err := s.readRELPFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("RELP %s: %s\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if s.Verbose {
+			log.Printf("RELP %s: <= %s\n", conn.RemoteAddr(), frame)
+		}
+
+		if !open && frame.Command != RELPOpen {
+			writeRELPFrame(w, frame.Txnr, RELPRsp,
+				"500 session not open")
+			writeRELPFrame(w, 0, RELPServerClose, "")
+			w.Flush()
+			return
+		}
+
+		switch frame.Command {
+		case RELPOpen:
+			offers := parseRELPOffers(frame.Data)
+			if !strings.Contains(offers["commands"], RELPSyslog) {
+				writeRELPFrame(w, frame.Txnr, RELPRsp,
+					"500 required command syslog not offered")
+				w.Flush()
+				return
+			}
+			open = true
+			err = writeRELPFrame(w, frame.Txnr, RELPRsp,
+				fmt.Sprintf("200 OK\nrelp_version=0\nrelp_software=%s\ncommands=%s",
+					RELPSoftware, RELPSyslog))
+
+		case RELPSyslog:
+			// The parse errors are permanent and the sender would
+			// resend the message forever if it was not acknowledged.
+			// The receive logs the invalid messages and they are
+			// acknowledged like the valid ones.
+			s.receive(frame.Data, source)
+			err = writeRELPFrame(w, frame.Txnr, RELPRsp, "200 OK")
+
+		case RELPClose:
+			writeRELPFrame(w, frame.Txnr, RELPRsp, "")
+			w.Flush()
+			return
+
+		default:
+			err = writeRELPFrame(w, frame.Txnr, RELPRsp,
+				fmt.Sprintf("500 unknown command '%s'", frame.Command))
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Printf("RELP %s: write failed: %s\n", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+func (s *Server) readRELPFrame(r *bufio.Reader) (*relpFrame, error) {
+	txnr, err := readRELPTxnr(r)
+	if err != nil {
+		return nil, err
+	}
+	command, err := r.ReadString(' ')
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	command = command[:len(command)-1]
+	if len(command) == 0 || len(command) > 32 {
+		return nil, fmt.Errorf("invalid command '%s'", command)
+	}
+
+	frame := &relpFrame{
+		Txnr:    txnr,
+		Command: command,
+	}
+
+	// DATALEN is followed by SP and DATA, or by the TRAILER when the
+	// DATALEN is 0.
+	var length int
+	for digits := 0; ; digits++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if b == '\n' && digits > 0 && length == 0 {
+			return frame, nil
+		}
+		if b == ' ' && digits > 0 {
+			break
+		}
+		if b < '0' || b > '9' || digits >= 9 {
+			return nil, fmt.Errorf("invalid DATALEN")
+		}
+		length = length*10 + int(b-'0')
+	}
+	if s.MaxMessageSize > 0 && length > s.MaxMessageSize {
+		return nil, errTooLong
+	}
+	frame.Data = make([]byte, length)
+	_, err = io.ReadFull(r, frame.Data)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if b != '\n' {
+		return nil, fmt.Errorf("invalid TRAILER")
+	}
+	return frame, nil
+}
+
+func readRELPTxnr(r *bufio.Reader) (int, error) {
+	var number int
+	for digits := 0; ; digits++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if digits > 0 {
+				err = unexpectedEOF(err)
+			}
+			return 0, err
+		}
+		if b == ' ' && digits > 0 {
+			return number, nil
+		}
+		if b < '0' || b > '9' || digits >= 9 {
+			return 0, fmt.Errorf("invalid TXNR")
+		}
+		number = number*10 + int(b-'0')
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func writeRELPFrame(w *bufio.Writer, txnr int, command, data string) error {
+	var err error
+	if len(data) == 0 {
+		_, err = fmt.Fprintf(w, "%d %s 0\n", txnr, command)
+	} else {
+		_, err = fmt.Fprintf(w, "%d %s %d %s\n", txnr, command, len(data),
+			data)
+	}
+	return err
+}
+
+func parseRELPOffers(data []byte) map[string]string {
+	result := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		idx := strings.IndexByte(line, '=')
+		if idx < 0 {
+			result[line] = ""
+		} else {
+			result[line[:idx]] = line[idx+1:]
+		}
+	}
+	return result
+}
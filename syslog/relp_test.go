@@ -0,0 +1,212 @@
+//
+// relp_test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markkurossi/datalog"
+)
+
+// testDB records the facts that are added to the clause database.
+type testDB struct {
+	m     sync.Mutex
+	facts []*datalog.Clause
+}
+
+func (db *testDB) Add(clause *datalog.Clause) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	db.facts = append(db.facts, clause)
+}
+
+func (db *testDB) Get(atom *datalog.Atom,
+	limits datalog.Predicates) []*datalog.Clause {
+	return nil
+}
+
+func (db *testDB) Sync() {
+}
+
+// events returns the number of events in the clause database.
+func (db *testDB) events() int {
+	db.m.Lock()
+	defer db.m.Unlock()
+
+	var count int
+	for _, c := range db.facts {
+		if c.Head.Predicate.String() == "syslog_ref" {
+			count++
+		}
+	}
+	return count
+}
+
+var relpFrameTests = []struct {
+	input   string
+	txnr    int
+	command string
+	data    string
+	err     string
+}{
+	{input: "1 syslog 5 hello\n", txnr: 1, command: "syslog", data: "hello"},
+	{input: "2 close 0\n", txnr: 2, command: "close"},
+	{input: "3 close 0 \n", txnr: 3, command: "close"},
+	{input: "4 syslog 3 a\nb\n", txnr: 4, command: "syslog", data: "a\nb"},
+	{input: "999999999 rsp 6 200 OK\n", txnr: 999999999, command: "rsp",
+		data: "200 OK"},
+	{input: "1 syslog 5 helloX", err: "invalid TRAILER"},
+	{input: "1 syslog 5 hello", err: io.ErrUnexpectedEOF.Error()},
+	{input: "1 syslog 5 hel", err: io.ErrUnexpectedEOF.Error()},
+	{input: "1 syslog 9 123456789\n", err: errTooLong.Error()},
+	{input: "1 syslog\n", err: io.ErrUnexpectedEOF.Error()},
+	{input: "1 syslog 5a hello\n", err: "invalid DATALEN"},
+	{input: "1 syslog  hello\n", err: "invalid DATALEN"},
+	{input: "1 syslog 1234567890 x\n", err: "invalid DATALEN"},
+	{input: "1  5 hello\n", err: "invalid command ''"},
+	{input: "x syslog 0\n", err: "invalid TXNR"},
+	{input: " syslog 0\n", err: "invalid TXNR"},
+	{input: "1234567890 syslog 0\n", err: "invalid TXNR"},
+	{input: "", err: io.EOF.Error()},
+	{input: "12", err: io.ErrUnexpectedEOF.Error()},
+}
+
+func TestReadRELPFrame(t *testing.T) {
+	s := New(new(testDB))
+	s.MaxMessageSize = 8
+
+	for _, test := range relpFrameTests {
+		frame, err := s.readRELPFrame(bufio.NewReader(
+			strings.NewReader(test.input)))
+		if len(test.err) > 0 {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: got error %v, expected %s", test.input, err,
+					test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: readRELPFrame failed: %s", test.input, err)
+			continue
+		}
+		if frame.Txnr != test.txnr || frame.Command != test.command ||
+			string(frame.Data) != test.data {
+			t.Errorf("%q: got %d %s %q", test.input, frame.Txnr,
+				frame.Command, frame.Data)
+		}
+	}
+}
+
+// testFrame formats a RELP frame.
+func testFrame(txnr int, command, data string) string {
+	if len(data) == 0 {
+		return fmt.Sprintf("%d %s 0\n", txnr, command)
+	}
+	return fmt.Sprintf("%d %s %d %s\n", txnr, command, len(data), data)
+}
+
+var (
+	testOffers = "relp_version=0\ncommands=syslog"
+	testOpenOK = "200 OK\nrelp_version=0\nrelp_software=" + RELPSoftware +
+		"\ncommands=syslog"
+	testEvent = "<13>Mar  1 12:00:00 host1 app[1]: message"
+)
+
+var relpSessionTests = []struct {
+	name   string
+	input  string
+	output string
+	events int
+}{
+	{
+		name:  "syslog before open",
+		input: testFrame(1, "syslog", testEvent),
+		output: testFrame(1, "rsp", "500 session not open") +
+			testFrame(0, "serverclose", ""),
+	},
+	{
+		name:   "open without syslog",
+		input:  testFrame(1, "open", "relp_version=0"),
+		output: testFrame(1, "rsp", "500 required command syslog not offered"),
+	},
+	{
+		name: "session",
+		input: testFrame(1, "open", testOffers) +
+			testFrame(2, "syslog", testEvent) +
+			testFrame(3, "syslog", "invalid") +
+			testFrame(4, "unknown", "") +
+			testFrame(5, "close", "") +
+			testFrame(6, "syslog", testEvent),
+		output: testFrame(1, "rsp", testOpenOK) +
+			testFrame(2, "rsp", "200 OK") +
+			testFrame(3, "rsp", "200 OK") +
+			testFrame(4, "rsp", "500 unknown command 'unknown'") +
+			testFrame(5, "rsp", ""),
+		events: 1,
+	},
+	{
+		name: "invalid frame",
+		input: testFrame(1, "open", testOffers) +
+			"2 syslog 5x",
+		output: testFrame(1, "rsp", testOpenOK),
+	},
+	{
+		name: "message too long",
+		input: testFrame(1, "open", testOffers) +
+			testFrame(2, "syslog", strings.Repeat("x", 100)),
+		output: testFrame(1, "rsp", testOpenOK),
+	},
+}
+
+func TestRELPSession(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, test := range relpSessionTests {
+		db := new(testDB)
+		s := New(db)
+		s.MaxMessageSize = 64
+
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			s.serveRELPConn(server)
+			close(done)
+		}()
+		go func() {
+			client.Write([]byte(test.input))
+		}()
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+		output, err := ioutil.ReadAll(client)
+		if err != nil {
+			t.Errorf("%s: read failed: %s", test.name, err)
+		}
+		client.Close()
+		<-done
+
+		if string(output) != test.output {
+			t.Errorf("%s: got\n%s\nexpected\n%s", test.name, output,
+				test.output)
+		}
+		if n := db.events(); n != test.events {
+			t.Errorf("%s: got %d events, expected %d", test.name, n,
+				test.events)
+		}
+	}
+}
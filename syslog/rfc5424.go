@@ -0,0 +1,278 @@
+//
+// rfc5424.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var reRFC5424 = regexp.MustCompile(`^<\d{1,3}>[1-9]\d{0,2} `)
+
+// NilValue defines the RFC 5424 NILVALUE.
+const NilValue = "-"
+
+var bom = []byte{0xef, 0xbb, 0xbf}
+
+// SDElement implements RFC 5424 STRUCTURED-DATA elements.
+type SDElement struct {
+	ID     string
+	Params []SDParam
+}
+
+func (e *SDElement) String() string {
+	var str = "[" + e.ID
+	for _, p := range e.Params {
+		str += fmt.Sprintf(" %s=\"%s\"", p.Name, sdEscaper.Replace(p.Value))
+	}
+	return str + "]"
+}
+
+// Param returns the value of the named parameter. The function
+// returns false if the element does not have the parameter.
+func (e *SDElement) Param(name string) (string, bool) {
+	for _, p := range e.Params {
+		if p.Name == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// SDParam implements RFC 5424 SD-PARAMs.
+type SDParam struct {
+	Name  string
+	Value string
+}
+
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+type parser5424 struct {
+	data []byte
+	ofs  int
+}
+
+func (p *parser5424) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("Invalid RFC 5424 event at %d: %s: '%s'",
+		p.ofs, fmt.Sprintf(format, a...), string(p.data))
+}
+
+// field reads the next SP-terminated header field.
+func (p *parser5424) field(name string) (string, error) {
+	idx := bytes.IndexByte(p.data[p.ofs:], ' ')
+	if idx <= 0 {
+		return "", p.errorf("truncated %s", name)
+	}
+	val := string(p.data[p.ofs : p.ofs+idx])
+	p.ofs += idx + 1
+	return val, nil
+}
+
+func parseRFC5424(data []byte) (*Event, error) {
+	p := &parser5424{
+		data: data,
+	}
+	end := bytes.IndexByte(data, '>')
+	if end < 0 {
+		return nil, p.errorf("PRI expected")
+	}
+	priority, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || priority > 191 {
+		return nil, p.errorf("invalid PRI")
+	}
+	p.ofs = end + 1
+
+	val, err := p.field("VERSION")
+	if err != nil {
+		return nil, err
+	}
+	version, err := strconv.Atoi(val)
+	if err != nil {
+		return nil, p.errorf("invalid VERSION '%s'", val)
+	}
+
+	event := &Event{
+		Facility: Facility(priority / 8),
+		Severity: Severity(priority % 8),
+		Version:  version,
+	}
+
+	val, err = p.field("TIMESTAMP")
+	if err != nil {
+		return nil, err
+	}
+	event.RawTimestamp = val
+	if val == NilValue {
+		event.timestamp = tsInvalid
+	} else {
+		event.Timestamp, err = time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			return nil, p.errorf("invalid TIMESTAMP: %s", err)
+		}
+	}
+
+	event.Hostname, err = p.nilField("HOSTNAME")
+	if err != nil {
+		return nil, err
+	}
+	event.Ident, err = p.nilField("APP-NAME")
+	if err != nil {
+		return nil, err
+	}
+	event.ProcID, err = p.nilField("PROCID")
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(event.ProcID)
+	if err == nil {
+		event.Pid = pid
+	}
+	event.MsgID, err = p.nilField("MSGID")
+	if err != nil {
+		return nil, err
+	}
+
+	event.Data, err = p.structuredData()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.ofs < len(p.data) {
+		if p.data[p.ofs] != ' ' {
+			return nil, p.errorf("SP expected after STRUCTURED-DATA")
+		}
+		p.ofs++
+		msg := p.data[p.ofs:]
+		if bytes.HasPrefix(msg, bom) {
+			msg = msg[len(bom):]
+			if !utf8.Valid(msg) {
+				return nil, p.errorf("invalid UTF-8 MSG")
+			}
+		}
+		event.Message = string(msg)
+	}
+
+	return event, nil
+}
+
+func (p *parser5424) nilField(name string) (string, error) {
+	val, err := p.field(name)
+	if err != nil {
+		return "", err
+	}
+	if val == NilValue {
+		return "", nil
+	}
+	return val, nil
+}
+
+func (p *parser5424) structuredData() ([]*SDElement, error) {
+	if p.ofs >= len(p.data) {
+		return nil, p.errorf("truncated STRUCTURED-DATA")
+	}
+	if p.data[p.ofs] == '-' {
+		p.ofs++
+		return nil, nil
+	}
+
+	var result []*SDElement
+	for p.ofs < len(p.data) && p.data[p.ofs] == '[' {
+		p.ofs++
+		id, err := p.sdName("SD-ID")
+		if err != nil {
+			return nil, err
+		}
+		element := &SDElement{
+			ID: id,
+		}
+		for {
+			if p.ofs >= len(p.data) {
+				return nil, p.errorf("truncated SD-ELEMENT")
+			}
+			if p.data[p.ofs] == ']' {
+				p.ofs++
+				break
+			}
+			if p.data[p.ofs] != ' ' {
+				return nil, p.errorf("SP expected in SD-ELEMENT")
+			}
+			p.ofs++
+			name, err := p.sdName("PARAM-NAME")
+			if err != nil {
+				return nil, err
+			}
+			if p.ofs+1 >= len(p.data) ||
+				p.data[p.ofs] != '=' || p.data[p.ofs+1] != '"' {
+				return nil, p.errorf("'=\"' expected after PARAM-NAME")
+			}
+			p.ofs += 2
+			value, err := p.sdValue()
+			if err != nil {
+				return nil, err
+			}
+			element.Params = append(element.Params, SDParam{
+				Name:  name,
+				Value: value,
+			})
+		}
+		result = append(result, element)
+	}
+	if len(result) == 0 {
+		return nil, p.errorf("STRUCTURED-DATA expected")
+	}
+	return result, nil
+}
+
+// sdName reads an SD-NAME: 1*32 printable US-ASCII characters
+// except '=', SP, ']', and '"'.
+func (p *parser5424) sdName(name string) (string, error) {
+	start := p.ofs
+	for ; p.ofs < len(p.data); p.ofs++ {
+		ch := p.data[p.ofs]
+		if ch <= 32 || ch >= 127 || ch == '=' || ch == ']' || ch == '"' {
+			break
+		}
+	}
+	if p.ofs == start || p.ofs-start > 32 {
+		return "", p.errorf("invalid %s", name)
+	}
+	return string(p.data[start:p.ofs]), nil
+}
+
+// sdValue reads a PARAM-VALUE up to and including its closing '"'.
+func (p *parser5424) sdValue() (string, error) {
+	var value []byte
+	for ; p.ofs < len(p.data); p.ofs++ {
+		ch := p.data[p.ofs]
+		switch ch {
+		case '"':
+			p.ofs++
+			if !utf8.Valid(value) {
+				return "", p.errorf("invalid UTF-8 PARAM-VALUE")
+			}
+			return string(value), nil
+
+		case '\\':
+			if p.ofs+1 < len(p.data) {
+				switch p.data[p.ofs+1] {
+				case '"', '\\', ']':
+					p.ofs++
+					ch = p.data[p.ofs]
+				}
+			}
+		}
+		value = append(value, ch)
+	}
+	return "", p.errorf("unterminated PARAM-VALUE")
+}
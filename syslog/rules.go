@@ -0,0 +1,189 @@
+//
+// rules.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/markkurossi/datalog"
+	"github.com/markkurossi/lgrep/directive"
+)
+
+// LoadHandlers loads handler definitions from the file and adds
+// their routes to the server's Routes. The handlers are defined with
+// the handler directives:
+//
+//	%@ handler IDENT PREDICATE REGEXP [NAME:TYPE...] [host=HOST] [facility=NAME[,NAME...]]
+//
+// The directive adds a pattern for the handler of the IDENT pattern,
+// see Pattern for the pattern specifications. The optional host and
+// facility options limit the handler to the events of the HOST
+// pattern and the named facilities. The patterns are matched in the
+// order they are defined and the first matching pattern adds the
+// PREDICATE fact with the event terms and the regular expression
+// captures. The optional NAME:TYPE arguments set the types of the
+// named captures: string (default), int, ip, or symbol. If none of the
+// patterns match, the event is passed to the next matching route. The
+// routes of the file are tried in the order of their first directives
+// and before the previously loaded and the built-in routes. The file
+// is re-read when the server is reloaded.
+func (s *Server) LoadHandlers(file string) error {
+	defs, err := parseHandlers(file)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	s.Routes = append(handlerRoutes(defs), s.Routes...)
+	s.handlerFiles = append(s.handlerFiles, file)
+	s.m.Unlock()
+	return nil
+}
+
+// handlerDef defines a handler that is loaded from handler
+// directives.
+type handlerDef struct {
+	route   *Route
+	matches []match
+}
+
+// parseHandlers parses the handler directives from the file. The
+// function returns the handler definitions in the order of their
+// first directives. The directives with the same IDENT, host, and
+// facility arguments define the same handler.
+func parseHandlers(file string) ([]*handlerDef, error) {
+	directives, err := directive.ParseFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var result []*handlerDef
+	defs := make(map[string]*handlerDef)
+	for _, d := range directives {
+		if d.Name != "handler" {
+			continue
+		}
+		if len(d.Args) < 3 {
+			return nil, d.Errorf("usage: IDENT PREDICATE REGEXP [NAME:TYPE...] [host=HOST] [facility=NAME[,NAME...]]")
+		}
+		route, err := parseRoute(d)
+		if err != nil {
+			return nil, err
+		}
+		m, err := parseMatch(d)
+		if err != nil {
+			return nil, err
+		}
+		key := route.String()
+		def, ok := defs[key]
+		if !ok {
+			def = &handlerDef{
+				route: route,
+			}
+			defs[key] = def
+			result = append(result, def)
+		}
+		def.matches = append(def.matches, m)
+	}
+	return result, nil
+}
+
+// parseRoute parses the IDENT argument and the host and facility
+// options of the handler directive.
+func parseRoute(d *directive.Directive) (*Route, error) {
+	route, err := NewRoute(d.Args[0], nil)
+	if err != nil {
+		return nil, d.Errorf("%s", err)
+	}
+	for _, arg := range d.Args[3:] {
+		if !isOption(arg) {
+			continue
+		}
+		idx := strings.IndexByte(arg, '=')
+		key, value := arg[:idx], arg[idx+1:]
+		switch key {
+		case "host":
+			route.Hostname, err = NewPattern(value)
+			if err != nil {
+				return nil, d.Errorf("%s", err)
+			}
+
+		case "facility":
+			for _, name := range strings.Split(value, ",") {
+				if !isFacility(name) {
+					return nil, d.Errorf("unknown facility '%s'", name)
+				}
+				route.Facilities = append(route.Facilities, name)
+			}
+
+		default:
+			return nil, d.Errorf("unknown option '%s'", key)
+		}
+	}
+	return route, nil
+}
+
+// isOption tests if the handler directive argument is a KEY=VALUE
+// option. The option values can contain ':' but the capture names can
+// not contain '='.
+func isOption(arg string) bool {
+	idx := strings.IndexByte(arg, '=')
+	return idx > 0 && strings.IndexByte(arg[:idx], ':') < 0
+}
+
+// handlerRoutes returns the routes of the handler definitions.
+func handlerRoutes(defs []*handlerDef) []*Route {
+	var result []*Route
+	for _, def := range defs {
+		route := *def.route
+		route.Handler = def.handler()
+		result = append(result, &route)
+	}
+	return result
+}
+
+func parseMatch(d *directive.Directive) (match, error) {
+	predicate := d.Args[1]
+	if len(predicate) == 0 || strings.ContainsAny(predicate, "()\",.:~?%") {
+		return match{}, d.Errorf("invalid predicate '%s'", predicate)
+	}
+	re, err := regexp.Compile(d.Args[2])
+	if err != nil {
+		return match{}, d.Errorf("%s", err)
+	}
+	m := match{
+		P: predicate,
+		R: re,
+		T: make([]CaptureType, re.NumSubexp()),
+	}
+	for _, arg := range d.Args[3:] {
+		if isOption(arg) {
+			continue
+		}
+		parts := strings.Split(arg, ":")
+		if len(parts) != 2 {
+			return match{}, d.Errorf("invalid capture type '%s'", arg)
+		}
+		t, ok := captureTypes[parts[1]]
+		if !ok {
+			return match{}, d.Errorf("unknown capture type '%s'", parts[1])
+		}
+		idx := re.SubexpIndex(parts[0])
+		if idx < 0 {
+			return match{}, d.Errorf("unknown capture '%s'", parts[0])
+		}
+		m.T[idx-1] = t
+	}
+	return m, nil
+}
+
+func (def *handlerDef) handler() Handler {
+	return func(e *Event, db datalog.DB, verbose bool) bool {
+		return matchEvent(def.matches, e, db, verbose)
+	}
+}
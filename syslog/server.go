@@ -9,28 +9,116 @@
 package syslog
 
 import (
+	"bytes"
 	"encoding/hex"
 	"log"
 	"net"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/markkurossi/datalog"
 )
 
 // Server implements syslog server.
 type Server struct {
-	Verbose  bool
-	DB       datalog.DB
-	Handlers map[string]Handler
+	Verbose         bool
+	DB              datalog.DB
+	Routes          []*Route
+	MaxMessageSize  int
+	IdleTimeout     time.Duration
+	Timezones       map[string]*time.Location
+	DefaultLocation *time.Location
+	m               sync.Mutex
+	handlerFiles    []string
+	timezonesFile   string
+	IDs             IDStore
+	idm             sync.Mutex
+	nextID          uint64
+	lastID          uint64
 }
 
+// IDStore persists the event IDs so that the event references stay
+// unique over server restarts. The ReserveIDs function reserves n
+// consecutive IDs and returns the first reserved ID.
+type IDStore interface {
+	ReserveIDs(n uint64) (uint64, error)
+}
+
+// IDBlockSize defines the number of event IDs that are reserved from
+// the IDStore at a time.
+var IDBlockSize uint64 = 1024
+
+// Default limits for stream connections.
+const (
+	DefaultMaxMessageSize = 64 * 1024
+	DefaultIdleTimeout    = 5 * time.Minute
+)
+
 // New creates a new syslog server.
 func New(db datalog.DB) *Server {
 	return &Server{
-		DB: db,
-		Handlers: map[string]Handler{
-			"sshd": SSHD,
-		},
+		DB:              db,
+		Routes:          builtinRoutes(),
+		MaxMessageSize:  DefaultMaxMessageSize,
+		IdleTimeout:     DefaultIdleTimeout,
+		DefaultLocation: time.UTC,
+		nextID:          1,
+	}
+}
+
+// builtinRoutes returns the routes of the built-in handlers. The
+// OpenSSH 9.8 and later versions log the authentication messages with
+// the sshd-session ident.
+func builtinRoutes() []*Route {
+	return []*Route{
+		MustRoute("kernel", Kmsg),
+		MustRoute("regex:^postfix(/|$)", Postfix),
+		MustRoute("sshd", SSHD),
+		MustRoute("sshd-session", SSHD),
+		MustRoute("sudo", Sudo),
+		MustRoute("systemd", Systemd),
+		MustRoute("systemd-logind", Logind),
+	}
+}
+
+// Reload re-reads the handler definitions and the time zones files.
+// The reloaded handler definitions replace all handlers, except the
+// built-in handlers. If any file has errors, the function returns
+// the error and the server keeps its current configuration.
+func (s *Server) Reload() error {
+	s.m.Lock()
+	handlerFiles := s.handlerFiles
+	timezonesFile := s.timezonesFile
+	s.m.Unlock()
+
+	routes := builtinRoutes()
+	for _, file := range handlerFiles {
+		defs, err := parseHandlers(file)
+		if err != nil {
+			return err
+		}
+		routes = append(handlerRoutes(defs), routes...)
+	}
+	var timezones map[string]*time.Location
+	var loc *time.Location
+	if len(timezonesFile) > 0 {
+		var err error
+		timezones, loc, err = parseTimezones(timezonesFile)
+		if err != nil {
+			return err
+		}
 	}
+
+	s.m.Lock()
+	s.Routes = routes
+	if len(timezonesFile) > 0 {
+		s.Timezones = timezones
+		s.DefaultLocation = loc
+	}
+	s.m.Unlock()
+
+	return nil
 }
 
 // ServeUDP handles the UDP syslog events from the specified UDP
@@ -47,26 +135,98 @@ func (s *Server) ServeUDP(address string) error {
 	defer conn.Close()
 	log.Printf("Syslog UDP: listening at %s\n", addr)
 
-	var buf [1024]byte
+	var buf [65536]byte
 	for {
-		n, _, err := conn.ReadFromUDP(buf[:])
+		n, peer, err := conn.ReadFromUDP(buf[:])
 		if err != nil {
 			log.Printf("ReadFromUDP: %s\n", err)
 			continue
 		}
-		event, err := Parse(buf[:n])
+		s.receive(buf[:n], &Source{
+			Transport: "udp",
+			Listener:  conn.LocalAddr().String(),
+			Addr:      peer,
+		})
+	}
+}
+
+// SetNextID sets the ID of the next dispatched event. The following
+// events get consecutive IDs and the IDs are not reserved from the
+// IDStore.
+func (s *Server) SetNextID(id uint64) {
+	s.idm.Lock()
+	s.nextID = id
+	s.lastID = ^uint64(0)
+	s.idm.Unlock()
+}
+
+// newID returns the next event ID. The IDs start from 1 and they are
+// reserved in blocks from the IDStore, if set. If the reservation
+// fails, the next block continues from the previous block without
+// persistence.
+func (s *Server) newID() uint64 {
+	s.idm.Lock()
+	defer s.idm.Unlock()
+
+	if s.IDs != nil && s.nextID > s.lastID {
+		first, err := s.IDs.ReserveIDs(IDBlockSize)
 		if err != nil {
-			log.Printf("Failed to parse syslog event: %s\n%s", err,
-				hex.Dump(buf[:n]))
-			continue
+			log.Printf("Failed to reserve event IDs: %s\n", err)
+			first = s.nextID
 		}
-		fn, ok := s.Handlers[event.Ident]
-		if ok {
-			fn(event, s.DB, s.Verbose)
-		} else {
-			Default(event, s.DB, s.Verbose)
+		if first > s.nextID {
+			s.nextID = first
 		}
+		s.lastID = s.nextID + IDBlockSize - 1
+	}
+	id := s.nextID
+	s.nextID++
+	return id
+}
 
-		s.DB.Sync()
+// receive parses the syslog message and dispatches the resulting
+// event. The source describes the message's transport. If the source
+// does not specify the receive time, the event is stamped with the
+// current time. The function returns when the event has been
+// committed to the clause database.
+func (s *Server) receive(data []byte, source *Source) error {
+	return s.receiveWith(parse, data, source)
+}
+
+func (s *Server) receiveWith(parse func(data []byte) (*Event, error),
+	data []byte, source *Source) error {
+
+	data = bytes.TrimRight(data, "\r\n")
+	event, err := parse(data)
+	if err != nil {
+		log.Printf("Failed to parse syslog event: %s\n%s", err,
+			hex.Dump(data))
+		return err
+	}
+	if source.Received.IsZero() {
+		src := *source
+		src.Received = time.Now()
+		source = &src
 	}
+	event.Source = source
+	event.ResolveTimestamp(source.Received, s.Location(event))
+	s.Dispatch(event)
+	return nil
+}
+
+// Dispatch passes the event to the handlers of its matching routes
+// and adds the event's companion facts to the clause database. The
+// routes are tried in order until a handler recognizes the event and
+// the unrecognized events are passed to the Default handler. The
+// event ID is assigned from the server's event counter.
+func (s *Server) Dispatch(event *Event) {
+	event.ID = strconv.FormatUint(s.newID(), 10)
+
+	s.m.Lock()
+	routes := s.Routes
+	s.m.Unlock()
+	dispatch(routes, event, s.DB, s.Verbose)
+	EventFacts(event, s.DB, s.Verbose)
+
+	s.DB.Sync()
 }
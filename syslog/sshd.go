@@ -9,17 +9,11 @@
 package syslog
 
 import (
-	"fmt"
 	"regexp"
 
 	"github.com/markkurossi/datalog"
 )
 
-type match struct {
-	P string
-	R *regexp.Regexp
-}
-
 var matches = []match{
 	// Server listening on 0.0.0.0 port 22.
 	{
@@ -140,30 +134,8 @@ var matches = []match{
 	},
 }
 
-// SSHD implements the Handler interface for SSHD syslog events.
-func SSHD(e *Event, db datalog.DB, verbose bool) {
-	for _, matcher := range matches {
-		m := matcher.R.FindStringSubmatch(e.Message)
-		if m == nil {
-			continue
-		}
-		event(db, matcher.P, e, m[1:], verbose)
-		return
-	}
-	fmt.Printf("%% SSHD: %s\n", e.Message)
-}
-
-func event(db datalog.DB, predicate string, e *Event, extra []string,
-	verbose bool) {
-
-	terms := EventTerms(e)
-	for _, e := range extra {
-		terms = append(terms, datalog.NewTermConstant(e, true))
-	}
-	sym, _ := datalog.Intern(predicate, false)
-	clause := datalog.NewClause(datalog.NewAtom(sym, terms), nil)
-	if verbose {
-		fmt.Printf("%s.\n", clause)
-	}
-	db.Add(clause)
+// SSHD implements the Handler interface for SSHD syslog events. The
+// handler does not recognize the unknown messages.
+func SSHD(e *Event, db datalog.DB, verbose bool) bool {
+	return matchEvent(matches, e, db, verbose)
 }
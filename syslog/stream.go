@@ -0,0 +1,97 @@
+//
+// stream.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var errPending = errors.New("pending data")
+
+// Stream splits captured syslog stream data into messages. The
+// stream data is added as it is captured and the complete messages
+// are returned with the same framing as the TCP server uses.
+type Stream struct {
+	max      int
+	data     []byte
+	closed   bool
+	failed   bool
+	detected bool
+	octets   bool
+}
+
+// NewStream creates a new stream. The max specifies the maximum
+// message size.
+func NewStream(max int) *Stream {
+	return &Stream{
+		max: max,
+	}
+}
+
+// Write adds data to the stream.
+func (s *Stream) Write(data []byte) {
+	if !s.failed {
+		s.data = append(s.data, data...)
+	}
+}
+
+// Close marks the end of the stream. After the stream is closed,
+// Next returns the remaining non-delimited data as the last message.
+func (s *Stream) Close() {
+	s.closed = true
+}
+
+// Next returns the next complete message from the stream. The
+// function returns nil if the stream does not have a complete
+// message. If the stream has a framing error, the function returns
+// the error and the rest of the stream is discarded, like the TCP
+// server closes the connection.
+func (s *Stream) Next() ([]byte, error) {
+	if s.failed || len(s.data) == 0 {
+		return nil, nil
+	}
+	r := bytes.NewReader(s.data)
+	var in io.Reader = r
+	if !s.closed {
+		in = &pendingReader{r}
+	}
+	f := newFrameReader(in, s.max)
+	f.detected = s.detected
+	f.octets = s.octets
+
+	frame, err := f.Next()
+	if err != nil {
+		if err == errPending || err == io.EOF {
+			return nil, nil
+		}
+		s.failed = true
+		s.data = nil
+		return nil, err
+	}
+	s.detected = true
+	s.octets = f.octets
+	s.data = s.data[len(s.data)-r.Len()-f.r.Buffered():]
+	return frame, nil
+}
+
+// pendingReader returns errPending instead of io.EOF so that the
+// frameReader does not return incomplete messages.
+type pendingReader struct {
+	r io.Reader
+}
+
+func (r *pendingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		err = errPending
+	}
+	return n, err
+}
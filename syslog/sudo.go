@@ -0,0 +1,123 @@
+//
+// sudo.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/markkurossi/datalog"
+)
+
+var sudoMatches = []match{
+	// pam_unix(sudo:session): session opened for user root(uid=0) by mtr(uid=1000)
+	{
+		P: "sudo_session_open",
+		R: regexp.MustCompile(`^pam_unix\(sudo:session\): session opened for user ([^\s(]+)(?:\(uid=\d+\))? by (\S*?)\(uid=(\d+)\)`),
+		T: []CaptureType{CaptureString, CaptureString, CaptureInt},
+	},
+	// pam_unix(sudo:session): session closed for user root
+	{
+		P: "sudo_session_close",
+		R: regexp.MustCompile(`^pam_unix\(sudo:session\): session closed for user (\S+)`),
+	},
+	// pam_unix(sudo:auth): authentication failure; logname=mtr uid=1000 euid=0 tty=/dev/pts/0 ruser=mtr rhost=  user=mtr
+	{
+		P: "sudo_authentication_failure",
+		R: regexp.MustCompile(`^pam_unix\(sudo:auth\): authentication failure; logname=(\S*) uid=(\S*) euid=(\S*) tty=(\S*) ruser=(\S*) rhost=(\S*)  user=(\S*)`),
+	},
+}
+
+var reSudoAttempts = regexp.MustCompile(`^(\d+) incorrect password attempts?$`)
+
+// Sudo implements the Handler interface for sudo syslog events. The
+// sudo command log lines have the invoking user and the
+// semicolon-separated KEY=VALUE fields of the command:
+//
+//	mtr : TTY=pts/0 ; PWD=/home/mtr ; USER=root ; COMMAND=/usr/bin/id
+//
+// The rejected commands have the reason before the fields. The
+// handler adds the following facts with the event terms followed by
+// the listed terms:
+//
+//	sudo_command(User, TTY, PWD, RunAs, Command)
+//	sudo_auth_failure(User, Attempts, TTY, PWD, RunAs, Command)
+//	sudo_not_in_sudoers(User, TTY, PWD, RunAs, Command)
+//	sudo_command_not_allowed(User, TTY, PWD, RunAs, Command)
+//	sudo_session_open(RunAs, User, UID)
+//	sudo_session_close(RunAs)
+//	sudo_authentication_failure(Logname, UID, EUID, TTY, RUser, RHost, User)
+//
+// The handler does not recognize the other events.
+func Sudo(e *Event, db datalog.DB, verbose bool) bool {
+	if matchEvent(sudoMatches, e, db, verbose) {
+		return true
+	}
+	idx := strings.Index(e.Message, " : ")
+	if idx <= 0 {
+		return false
+	}
+	user := e.Message[:idx]
+	fields := e.Message[idx+3:]
+
+	// The command is the last field and it can contain the field
+	// separator.
+	idx = strings.Index(fields, "COMMAND=")
+	if idx < 0 {
+		return false
+	}
+	command := fields[idx+len("COMMAND="):]
+	fields = strings.TrimSuffix(fields[:idx], " ; ")
+
+	var status string
+	values := make(map[string]string)
+	for _, field := range strings.Split(fields, " ; ") {
+		field = strings.TrimSpace(field)
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) == 2 && kv[0] == strings.ToUpper(kv[0]) {
+			values[kv[0]] = kv[1]
+		} else if len(status) == 0 {
+			status = field
+		}
+	}
+
+	terms := []datalog.Term{
+		datalog.NewTermConstant(values["TTY"], true),
+		datalog.NewTermConstant(values["PWD"], true),
+		datalog.NewTermConstant(values["USER"], true),
+		datalog.NewTermConstant(command, true),
+	}
+	var predicate string
+	switch status {
+	case "":
+		predicate = "sudo_command"
+
+	case "user NOT in sudoers":
+		predicate = "sudo_not_in_sudoers"
+
+	case "command not allowed":
+		predicate = "sudo_command_not_allowed"
+
+	default:
+		m := reSudoAttempts.FindStringSubmatch(status)
+		if m == nil {
+			return false
+		}
+		predicate = "sudo_auth_failure"
+		terms = append([]datalog.Term{
+			datalog.NewTermConstant(m[1], false),
+		}, terms...)
+	}
+	terms = append([]datalog.Term{
+		datalog.NewTermConstant(user, true),
+	}, terms...)
+
+	fact(db, predicate, append(EventTerms(e), terms...), verbose)
+	return true
+}
@@ -0,0 +1,117 @@
+//
+// systemd.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"regexp"
+
+	"github.com/markkurossi/datalog"
+)
+
+// reUnit matches the systemd unit names.
+const reUnit = `[^\s/]+\.(?:service|socket|target|device|mount|automount|swap|timer|path|slice|scope)`
+
+// systemdMatches match the systemd job and unit messages. The systemd
+// 250 and later versions log the job messages with the unit name and
+// description:
+//
+//	Started nginx.service - A high performance web server.
+//
+// The earlier versions log only the unit description and the Unit of
+// their facts is empty.
+var systemdMatches = []match{
+	// Started nginx.service - A high performance web server and a reverse proxy server.
+	{
+		P: "unit_started",
+		R: regexp.MustCompile(`^Started (?:(` + reUnit + `)(?: - |$))?(.*?)\.?$`),
+		T: []CaptureType{CaptureSymbol},
+	},
+	// Stopped nginx.service - A high performance web server and a reverse proxy server.
+	{
+		P: "unit_stopped",
+		R: regexp.MustCompile(`^Stopped (?:(` + reUnit + `)(?: - |$))?(.*?)\.?$`),
+		T: []CaptureType{CaptureSymbol},
+	},
+	// Failed to start nginx.service - A high performance web server and a reverse proxy server.
+	{
+		P: "unit_start_failed",
+		R: regexp.MustCompile(`^Failed to start (?:(` + reUnit + `)(?: - |$))?(.*?)\.?$`),
+		T: []CaptureType{CaptureSymbol},
+	},
+	// nginx.service: Failed with result 'exit-code'.
+	{
+		P: "unit_failed",
+		R: regexp.MustCompile(`^(` + reUnit + `): Failed with result '([^']*)'`),
+		T: []CaptureType{CaptureSymbol, CaptureSymbol},
+	},
+	// nginx.service: Main process exited, code=exited, status=1/FAILURE
+	{
+		P: "unit_exited",
+		R: regexp.MustCompile(`^(` + reUnit + `): Main process exited, code=(\S+), status=(\d+)(?:/(\S+))?$`),
+		T: []CaptureType{CaptureSymbol, CaptureSymbol, CaptureInt,
+			CaptureSymbol},
+	},
+	// nginx.service: Scheduled restart job, restart counter is at 3.
+	{
+		P: "unit_restart",
+		R: regexp.MustCompile(`^(` + reUnit + `): Scheduled restart job, restart counter is at (\d+)`),
+		T: []CaptureType{CaptureSymbol, CaptureInt},
+	},
+}
+
+// Systemd implements the Handler interface for the systemd syslog
+// events. The handler adds the following facts with the event terms
+// followed by the listed terms:
+//
+//	unit_started(Unit, Description)
+//	unit_stopped(Unit, Description)
+//	unit_start_failed(Unit, Description)
+//	unit_failed(Unit, Result)
+//	unit_exited(Unit, Code, Status, StatusName)
+//	unit_restart(Unit, Counter)
+//
+// The unit_failed Result is the failure reason, like exit-code or
+// timeout. The unit_exited Code is exited, killed, or dumped, and the
+// Status is the exit code or the signal number. The unit_restart
+// Counter is the unit's restart counter. The handler does not
+// recognize the other events.
+func Systemd(e *Event, db datalog.DB, verbose bool) bool {
+	return matchEvent(systemdMatches, e, db, verbose)
+}
+
+var logindMatches = []match{
+	// New session 3 of user alice.
+	{
+		P: "login_session",
+		R: regexp.MustCompile(`^New session (\S+) of user ([^\s.]+(?:\.[^\s.]+)*)\.?$`),
+	},
+	// Session 3 logged out. Waiting for processes to exit.
+	{
+		P: "login_session_logout",
+		R: regexp.MustCompile(`^Session (\S+) logged out\.`),
+	},
+	// Removed session 3.
+	{
+		P: "login_session_removed",
+		R: regexp.MustCompile(`^Removed session (\S+?)\.?$`),
+	},
+}
+
+// Logind implements the Handler interface for the systemd-logind
+// syslog events. The handler adds the following facts with the event
+// terms followed by the listed terms:
+//
+//	login_session(Session, User)
+//	login_session_logout(Session)
+//	login_session_removed(Session)
+//
+// The handler does not recognize the other events.
+func Logind(e *Event, db datalog.DB, verbose bool) bool {
+	return matchEvent(logindMatches, e, db, verbose)
+}
@@ -0,0 +1,197 @@
+//
+// tcp.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+var errTooLong = errors.New("message too long")
+
+// ServeTCP handles the TCP syslog events from the specified TCP
+// address. The connections can use either the RFC 6587 octet-counting
+// or the newline-delimited (non-transparent) framing. The framing is
+// detected from the first frame of each connection.
+func (s *Server) ServeTCP(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("Syslog TCP: listening at %s\n", listener.Addr())
+
+	return s.serveStream(listener, s.serveConn)
+}
+
+func (s *Server) serveStream(listener net.Listener,
+	serve func(conn net.Conn)) error {
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			ne, ok := err.(net.Error)
+			if ok && ne.Temporary() {
+				log.Printf("Accept: %s\n", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go serve(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	source, err := s.connSource(conn)
+	if err != nil {
+		log.Printf("Syslog %s: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+
+	frames := newFrameReader(conn, s.MaxMessageSize)
+	for {
+		if s.IdleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+		}
+		frame, err := frames.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Syslog %s: %s\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		s.receive(frame, source)
+	}
+}
+
+// connSource creates the event source for the stream connection. For
+// TLS connections, the function completes the TLS handshake.
+func (s *Server) connSource(conn net.Conn) (*Source, error) {
+	source := &Source{
+		Transport: "tcp",
+		Listener:  conn.LocalAddr().String(),
+		Addr:      conn.RemoteAddr(),
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if ok {
+		source.Transport = "tls"
+		if s.IdleTimeout > 0 {
+			conn.SetDeadline(time.Now().Add(s.IdleTimeout))
+		}
+		err := tlsConn.Handshake()
+		if err != nil {
+			return nil, fmt.Errorf("TLS handshake failed: %s", err)
+		}
+		state := tlsConn.ConnectionState()
+		if len(state.VerifiedChains) > 0 {
+			source.Certificate = state.PeerCertificates[0]
+		}
+	}
+	return source, nil
+}
+
+// frameReader splits a syslog stream into messages.
+type frameReader struct {
+	r        *bufio.Reader
+	max      int
+	delims   string
+	detected bool
+	octets   bool
+}
+
+func newFrameReader(r io.Reader, max int) *frameReader {
+	return &frameReader{
+		r:      bufio.NewReader(r),
+		max:    max,
+		delims: "\n",
+	}
+}
+
+// Next returns the next message from the stream.
+func (f *frameReader) Next() ([]byte, error) {
+	if !f.detected {
+		b, err := f.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		f.octets = b[0] >= '0' && b[0] <= '9'
+		f.detected = true
+	}
+	if f.octets {
+		return f.nextOctetCounted()
+	}
+	return f.nextLine()
+}
+
+func (f *frameReader) nextOctetCounted() ([]byte, error) {
+	var length int
+	var digits int
+	for {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && digits > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if b == ' ' && digits > 0 {
+			break
+		}
+		if b < '0' || b > '9' || digits >= 10 {
+			return nil, fmt.Errorf("invalid octet count")
+		}
+		length = length*10 + int(b-'0')
+		digits++
+	}
+	if f.max > 0 && length > f.max {
+		return nil, errTooLong
+	}
+	frame := make([]byte, length)
+	_, err := io.ReadFull(f.r, frame)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (f *frameReader) nextLine() ([]byte, error) {
+	var frame []byte
+	for {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(frame) > 0 {
+				return frame, nil
+			}
+			return nil, err
+		}
+		if strings.IndexByte(f.delims, b) >= 0 {
+			if len(frame) == 0 {
+				continue
+			}
+			return frame, nil
+		}
+		if f.max > 0 && len(frame) >= f.max {
+			return nil, errTooLong
+		}
+		frame = append(frame, b)
+	}
+}
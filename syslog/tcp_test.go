@@ -0,0 +1,134 @@
+//
+// tcp_test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var frameTests = []struct {
+	name   string
+	input  string
+	delims string
+	frames []string
+	err    string
+}{
+	{
+		name:   "octet counting",
+		input:  "5 hello11 second\nline3 abc",
+		frames: []string{"hello", "second\nline", "abc"},
+		err:    io.EOF.Error(),
+	},
+	{
+		name:   "octet counting empty frame",
+		input:  "0 5 hello",
+		frames: []string{"", "hello"},
+		err:    io.EOF.Error(),
+	},
+	{
+		name:   "octet counting too long",
+		input:  "5 hello17 12345678901234567",
+		frames: []string{"hello"},
+		err:    errTooLong.Error(),
+	},
+	{
+		name:   "octet counting truncated frame",
+		input:  "5 hello5 hel",
+		frames: []string{"hello"},
+		err:    io.ErrUnexpectedEOF.Error(),
+	},
+	{
+		name:   "octet counting truncated count",
+		input:  "5 hello12",
+		frames: []string{"hello"},
+		err:    io.ErrUnexpectedEOF.Error(),
+	},
+	{
+		name:   "invalid octet count",
+		input:  "5 hello<13>msg\n",
+		frames: []string{"hello"},
+		err:    "invalid octet count",
+	},
+	{
+		name:   "octet count without length",
+		input:  "5 hello hello",
+		frames: []string{"hello"},
+		err:    "invalid octet count",
+	},
+	{
+		name:  "octet count overflow",
+		input: "12345678901 x",
+		err:   "invalid octet count",
+	},
+	{
+		name:   "LF framing",
+		input:  "<13>first\n<13>second 5 x\n",
+		frames: []string{"<13>first", "<13>second 5 x"},
+		err:    io.EOF.Error(),
+	},
+	{
+		name:   "LF framing empty lines",
+		input:  "\n\n<13>first\n\n<13>second\n\n",
+		frames: []string{"<13>first", "<13>second"},
+		err:    io.EOF.Error(),
+	},
+	{
+		name:   "LF framing without final LF",
+		input:  "<13>first\n<13>last",
+		frames: []string{"<13>first", "<13>last"},
+		err:    io.EOF.Error(),
+	},
+	{
+		name:   "LF framing too long",
+		input:  "<13>msg\n<13>message too long\n",
+		frames: []string{"<13>msg"},
+		err:    errTooLong.Error(),
+	},
+	{
+		name:   "NUL framing",
+		input:  "<13>first\x00<13>second\n\x00<13>third",
+		delims: "\x00\n",
+		frames: []string{"<13>first", "<13>second", "<13>third"},
+		err:    io.EOF.Error(),
+	},
+	{
+		name:  "empty stream",
+		input: "",
+		err:   io.EOF.Error(),
+	},
+}
+
+func TestFrameReader(t *testing.T) {
+	for _, test := range frameTests {
+		r := newFrameReader(strings.NewReader(test.input), 16)
+		if len(test.delims) > 0 {
+			r.delims = test.delims
+		}
+		var frames []string
+		var err error
+		for {
+			var frame []byte
+			frame, err = r.Next()
+			if err != nil {
+				break
+			}
+			frames = append(frames, string(frame))
+		}
+		if !reflect.DeepEqual(frames, test.frames) {
+			t.Errorf("%s: got frames %q, expected %q", test.name, frames,
+				test.frames)
+		}
+		if err.Error() != test.err {
+			t.Errorf("%s: got error %s, expected %s", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+//
+// timestamp.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+type timestampState int
+
+const (
+	tsResolved timestampState = iota
+	tsNoYear
+	tsInvalid
+)
+
+// BSDTimestamp defines the RFC 3164 timestamp format.
+const BSDTimestamp = "Jan _2 15:04:05"
+
+// parseBSDTimestamp parses the event's RFC 3164 raw timestamp. Some
+// senders use RFC 3339 timestamps with the RFC 3164 format and the
+// function accepts them too.
+func (e *Event) parseBSDTimestamp() {
+	var err error
+
+	e.Timestamp, err = time.Parse(BSDTimestamp, e.RawTimestamp)
+	if err == nil {
+		e.timestamp = tsNoYear
+		return
+	}
+	e.Timestamp, err = time.Parse(time.RFC3339Nano, e.RawTimestamp)
+	if err == nil {
+		e.timestamp = tsResolved
+		return
+	}
+	e.timestamp = tsInvalid
+}
+
+// ResolveTimestamp completes the event timestamp for the event that
+// was received at the argument time. If the event timestamp does not
+// specify the year, the function sets the timestamp's year to the
+// latest year that does not put the timestamp more than a week after
+// the receive time. The timestamps without time zone information are
+// interpreted in the argument location. If the event did not have a
+// valid timestamp, the function sets the timestamp to the receive
+// time. The original timestamp remains in the event's RawTimestamp
+// field.
+func (e *Event) ResolveTimestamp(received time.Time, loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch e.timestamp {
+	case tsNoYear:
+		e.Timestamp = inferYear(e.Timestamp, received, loc)
+
+	case tsInvalid:
+		e.Timestamp = received
+	}
+	e.timestamp = tsResolved
+}
+
+// maxFutureSkew defines how far in the future, relative to the
+// receive time, inferred timestamps can be.
+const maxFutureSkew = 7 * 24 * time.Hour
+
+func inferYear(ts, received time.Time, loc *time.Location) time.Time {
+	year := received.In(loc).Year()
+	for y := year + 1; y >= year-1; y-- {
+		t := time.Date(y, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(),
+			ts.Second(), ts.Nanosecond(), loc)
+		if t.Month() != ts.Month() {
+			// Feb 29 on a non-leap year.
+			continue
+		}
+		if t.Sub(received) <= maxFutureSkew {
+			return t
+		}
+	}
+	return received
+}
+
+// Location returns the time zone location for the event. The
+// location is resolved from the server's Timezones by the event
+// hostname and by the sender IP address. If neither is configured,
+// the function returns the server's default Location.
+func (s *Server) Location(event *Event) *time.Location {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	loc, ok := s.Timezones[event.Hostname]
+	if ok {
+		return loc
+	}
+	if event.Source != nil && event.Source.Addr != nil {
+		loc, ok = s.Timezones[addrIP(event.Source.Addr)]
+		if ok {
+			return loc
+		}
+	}
+	return s.DefaultLocation
+}
+
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return addr.String()
+		}
+		return host
+	}
+}
+
+// LoadTimezones loads the per-source time zones from the file. Each
+// line of the file maps a hostname or an IP address to a time zone
+// location name:
+//
+//	# Source	Location
+//	router1		Europe/Helsinki
+//	10.0.2.15	America/New_York
+//
+// The source "*" sets the server's default location. The file is
+// re-read when the server is reloaded.
+func (s *Server) LoadTimezones(file string) error {
+	timezones, loc, err := parseTimezones(file)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	s.Timezones = timezones
+	s.DefaultLocation = loc
+	s.timezonesFile = file
+	s.m.Unlock()
+
+	return nil
+}
+
+func parseTimezones(file string) (map[string]*time.Location,
+	*time.Location, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	timezones := make(map[string]*time.Location)
+	loc := time.UTC
+
+	scanner := bufio.NewScanner(f)
+	var line int
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("%s:%d: invalid time zone mapping",
+				file, line)
+		}
+		l, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s:%d: %s", file, line, err)
+		}
+		if fields[0] == "*" {
+			loc = l
+		} else {
+			timezones[fields[0]] = l
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return timezones, loc, nil
+}
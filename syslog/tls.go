@@ -0,0 +1,64 @@
+//
+// tls.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+)
+
+// ServeTLS handles the RFC 5425 syslog over TLS events from the
+// specified TCP address. The TLS connections use the octet-counting
+// framing but the server also accepts the newline-delimited framing
+// like ServeTCP. If the peer presents a client certificate that the
+// config verifies, the certificate is recorded as the
+// syslog_tls_peer(Ref, Subject, Fingerprint) fact for each event
+// received from the connection.
+func (s *Server) ServeTLS(address string, config *tls.Config) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	log.Printf("Syslog TLS: listening at %s\n", listener.Addr())
+
+	return s.serveStream(tls.NewListener(listener, config), s.serveConn)
+}
+
+// NewTLSConfig creates a TLS server configuration from the PEM
+// encoded certificate and private key files. If the caFile is not
+// empty, the configuration requires client certificates, signed by
+// the certificate authorities of the caFile.
+func NewTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(caFile) > 0 {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates found from '%s'", caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
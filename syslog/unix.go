@@ -0,0 +1,144 @@
+//
+// unix.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// ServeUnixgram handles the local syslog events from the Unix
+// datagram socket at path, like /dev/log. The events use the local
+// syslog format without the HOSTNAME field and the server sets the
+// event hostnames from os.Hostname. If the kernel reports the sender
+// credentials, they are recorded as the syslog_peercred(Ref, Pid, UID,
+// GID) fact for each event.
+func (s *Server) ServeUnixgram(path string) error {
+	parse, err := localParser()
+	if err != nil {
+		return err
+	}
+	RemoveStale(path)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{
+		Name: path,
+		Net:  "unixgram",
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = os.Chmod(path, 0666)
+	if err != nil {
+		return err
+	}
+	err = enableCred(conn)
+	if err != nil {
+		log.Printf("Syslog unixgram: peer credentials not available: %s\n",
+			err)
+	}
+	log.Printf("Syslog unixgram: listening at %s\n", path)
+
+	var buf [65536]byte
+	var oob [1024]byte
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf[:], oob[:])
+		if err != nil {
+			ne, ok := err.(net.Error)
+			if ok && ne.Temporary() {
+				log.Printf("ReadMsgUnix: %s\n", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.receiveWith(parse, buf[:n], &Source{
+			Transport: "unixgram",
+			Listener:  path,
+			Cred:      parseCred(oob[:oobn]),
+		})
+	}
+}
+
+// ServeUnix handles the local syslog events from the Unix stream
+// socket at path. The messages are separated by NUL or newline
+// characters. Otherwise the server handles the events like
+// ServeUnixgram, recording the peer process credentials of the
+// connection for each event.
+func (s *Server) ServeUnix(path string) error {
+	parse, err := localParser()
+	if err != nil {
+		return err
+	}
+	RemoveStale(path)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{
+		Name: path,
+		Net:  "unix",
+	})
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	err = os.Chmod(path, 0666)
+	if err != nil {
+		return err
+	}
+	log.Printf("Syslog unix: listening at %s\n", path)
+
+	return s.serveStream(listener, func(conn net.Conn) {
+		defer conn.Close()
+
+		source := &Source{
+			Transport: "unix",
+			Listener:  path,
+		}
+		unixConn, ok := conn.(*net.UnixConn)
+		if ok {
+			cred, err := peerCred(unixConn)
+			if err != nil {
+				log.Printf("Syslog unix: peer credentials not available: %s\n",
+					err)
+			}
+			source.Cred = cred
+		}
+
+		frames := newFrameReader(conn, s.MaxMessageSize)
+		frames.delims = "\x00\n"
+		for {
+			if s.IdleTimeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+			}
+			frame, err := frames.Next()
+			if err != nil {
+				return
+			}
+			s.receiveWith(parse, frame, source)
+		}
+	})
+}
+
+// RemoveStale removes the socket file of a previous server instance.
+// The function does not remove the path if it is not a socket.
+func RemoveStale(path string) {
+	fi, err := os.Lstat(path)
+	if err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+}
+
+func localParser() (func(data []byte) (*Event, error), error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return func(data []byte) (*Event, error) {
+		return ParseLocal(data, hostname)
+	}, nil
+}
@@ -0,0 +1,72 @@
+//
+// unix_linux.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package syslog
+
+import (
+	"net"
+	"syscall"
+)
+
+func enableCred(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET,
+			syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func parseCred(oob []byte) *Cred {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, msg := range msgs {
+		ucred, err := syscall.ParseUnixCredentials(&msg)
+		if err == nil {
+			return &Cred{
+				Pid: int(ucred.Pid),
+				UID: int(ucred.Uid),
+				GID: int(ucred.Gid),
+			}
+		}
+	}
+	return nil
+}
+
+func peerCred(conn *net.UnixConn) (*Cred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET,
+			syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return &Cred{
+		Pid: int(ucred.Pid),
+		UID: int(ucred.Uid),
+		GID: int(ucred.Gid),
+	}, nil
+}
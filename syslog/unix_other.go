@@ -0,0 +1,31 @@
+//
+// unix_other.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+//go:build !linux
+// +build !linux
+
+package syslog
+
+import (
+	"errors"
+	"net"
+)
+
+var errNoCred = errors.New("not supported on this platform")
+
+func enableCred(conn *net.UnixConn) error {
+	return errNoCred
+}
+
+func parseCred(oob []byte) *Cred {
+	return nil
+}
+
+func peerCred(conn *net.UnixConn) (*Cred, error) {
+	return nil, errNoCred
+}
@@ -0,0 +1,74 @@
+//
+// test.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/markkurossi/lgrep/harness"
+)
+
+// runTest runs the golden file tests of the test directories. The
+// directories are searched recursively for tests.
+func runTest(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	update := fs.Bool("update", false,
+		"Write the actual results to the expected results files.")
+	ref := fs.String("ref", harness.Reference.Format(time.RFC3339),
+		"Reference RFC 3339 time for inferring the years of timestamps.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: lgrep test [options] [DIR...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var err error
+	harness.Reference, err = time.Parse(time.RFC3339, *ref)
+	if err != nil {
+		log.Fatalf("Invalid reference time: %s\n", err)
+	}
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{harness.DefaultRoot}
+	}
+
+	var failed int
+	for _, root := range roots {
+		dirs, err := harness.Find(root)
+		if err != nil {
+			log.Fatalf("Failed to find tests: %s\n", err)
+		}
+		for _, dir := range dirs {
+			result := harness.Run(dir, *update)
+			switch {
+			case result.Err != nil:
+				fmt.Printf("FAIL\t%s\n\t%s\n", dir, result.Err)
+			case result.Failed():
+				fmt.Printf("FAIL\t%s\n", dir)
+				for _, d := range result.Diffs {
+					fmt.Printf("\t%s\n", d)
+				}
+			case *update:
+				fmt.Printf("updated\t%s\n", dir)
+			default:
+				fmt.Printf("ok\t%s\n", dir)
+			}
+			if result.Failed() {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
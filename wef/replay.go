@@ -0,0 +1,65 @@
+//
+// replay.go
+//
+// Copyright (c) 2018 Markku Rossi
+//
+// All rights reserved.
+//
+
+package wef
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ReadEvents reads the events of a saved WEF XML event file and calls
+// the function for each event. The file can contain a single event,
+// a sequence of events, as written by `wevtutil qe /f:xml`, or events
+// inside an Events root element.
+func ReadEvents(r io.Reader, fn func(e *Event) error) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Event" {
+			continue
+		}
+		e := &Event{}
+		err = decoder.DecodeElement(e, &start)
+		if err != nil {
+			return err
+		}
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Time returns the event creation time.
+func (e *Event) Time() (time.Time, error) {
+	return time.Parse(SystemTimeFormat, e.System.TimeCreated.SystemTime)
+}
+
+// Process adds the facts of the events to the clause database and
+// commits them.
+func (s *Server) Process(events ...*Event) {
+	for idx, e := range events {
+		if s.Verbose {
+			fmt.Printf("--- Event %d ----------------------------------\n",
+				idx)
+			e.Dump()
+		}
+		s.datalog(e)
+	}
+	s.DB.Sync()
+}
@@ -150,21 +150,17 @@ func (s *Server) subscriptions(w http.ResponseWriter, r *http.Request) {
 	case ActHeartbeat, ActEnd, ActSubscriptionEnd:
 
 	case ActEvents:
-		for idx, evt := range env.Body.Events {
+		var events []*Event
+		for _, evt := range env.Body.Events {
 			e := &Event{}
 			err = xml.Unmarshal([]byte(evt.Data), e)
 			if err != nil {
 				fmt.Printf("Failed to parse event: %s\n", err)
 				continue
 			}
-			if s.Verbose {
-				fmt.Printf("--- Event %d ----------------------------------\n",
-					idx)
-				e.Dump()
-			}
-			s.datalog(e)
+			events = append(events, e)
 		}
-		s.DB.Sync()
+		s.Process(events...)
 
 	default:
 		fmt.Printf("Unhandled action: %s\n", env.Header.Action)